@@ -32,7 +32,7 @@ func (conn *Conn) SetAuthorizer(auth Authorizer) error {
 		}
 		conn.releaseAuthorizer()
 		res := C.sqlite3_set_authorizer(conn.conn, nil, nil)
-		return reserr("SetAuthorizer", "", "", res)
+		return conn.reserr("SetAuthorizer", "", res)
 	}
 
 	authFuncs.mu.Lock()
@@ -47,7 +47,26 @@ func (conn *Conn) SetAuthorizer(auth Authorizer) error {
 	authFuncs.mu.Unlock()
 
 	res := C.sqlite3_go_set_authorizer(conn.conn, C.uintptr_t(id))
-	return reserr("SetAuthorizer", "", "", res)
+	if res != C.SQLITE_OK {
+		authFuncs.mu.Lock()
+		delete(authFuncs.m, id)
+		authFuncs.mu.Unlock()
+		return conn.reserr("SetAuthorizer", "", res)
+	}
+	conn.releaseAuthorizer()
+	conn.authorizer = id
+	return nil
+}
+
+// Authorizer returns the authorizer registered with SetAuthorizer,
+// or nil if there is none.
+func (conn *Conn) Authorizer() Authorizer {
+	if conn.authorizer == -1 {
+		return nil
+	}
+	authFuncs.mu.RLock()
+	defer authFuncs.mu.RUnlock()
+	return authFuncs.m[conn.authorizer]
 }
 
 func (conn *Conn) releaseAuthorizer() {
@@ -23,7 +23,12 @@ func TestSetAuthorizer(t *testing.T) {
 		lastAction = info.Action
 		return authResult
 	})
-	c.SetAuthorizer(auth)
+	if err := c.SetAuthorizer(auth); err != nil {
+		t.Fatal(err)
+	}
+	if c.Authorizer() == nil {
+		t.Error("Authorizer() = nil after SetAuthorizer")
+	}
 
 	t.Run("Allowed", func(t *testing.T) {
 		authResult = 0
@@ -51,4 +56,17 @@ func TestSetAuthorizer(t *testing.T) {
 			t.Errorf("action = %q; want SQLITE_SELECT", lastAction)
 		}
 	})
+
+	if err := c.SetAuthorizer(nil); err != nil {
+		t.Fatal(err)
+	}
+	if c.Authorizer() != nil {
+		t.Error("Authorizer() != nil after SetAuthorizer(nil)")
+	}
+	authResult = sqlite.SQLITE_DENY
+	stmt, _, err := c.PrepareTransient("SELECT 1;")
+	if err != nil {
+		t.Fatalf("cleared authorizer still denies: %v", err)
+	}
+	stmt.Finalize()
 }
@@ -31,11 +31,14 @@ import (
 // https://www.sqlite.org/c3ref/backup_finish.html
 type Backup struct {
 	ptr *C.sqlite3_backup
+	dst *Conn
 }
 
 // BackupToDB creates a complete backup of the srcDB on the src Conn to a new
 // database Conn at dstPath. The resulting dst connection is returned. This
-// will block until the entire backup is complete.
+// will block until the entire backup is complete, holding a read lock on
+// srcDB throughout. To back up a large database that is in use, see
+// sqlitex.Backup.
 //
 // If srcDB is "", then a default of "main" is used.
 //
@@ -70,7 +73,7 @@ func (src *Conn) BackupInit(srcDB, dstDB string, dst *Conn) (*Backup, error) {
 	var srcCDB, dstCDB *C.char
 	defer setCDB(dstDB, &dstCDB)()
 	defer setCDB(srcDB, &srcCDB)()
-	var b Backup
+	b := Backup{dst: dst}
 	b.ptr = C.sqlite3_backup_init(dst.conn, dstCDB, src.conn, srcCDB)
 	if b.ptr == nil {
 		res := C.sqlite3_errcode(dst.conn)
@@ -90,7 +93,7 @@ func setCDB(db string, cdb **C.char) func() {
 		return func() {}
 	}
 	*cdb = C.CString(db)
-	return func() { C.free(unsafe.Pointer(cdb)) }
+	return func() { C.free(unsafe.Pointer(*cdb)) }
 }
 
 // Step is called one or more times to transfer nPage pages at a time between
@@ -102,7 +105,7 @@ func setCDB(db string, cdb **C.char) func() {
 func (b *Backup) Step(nPage int) error {
 	res := C.sqlite3_backup_step(b.ptr, C.int(nPage))
 	if res != C.SQLITE_DONE {
-		return reserr("Backup.Step", "", "", res)
+		return b.dst.reserr("Backup.Step", "", res)
 	}
 	return nil
 }
@@ -113,7 +116,7 @@ func (b *Backup) Step(nPage int) error {
 func (b *Backup) Finish() error {
 	res := C.sqlite3_backup_finish(b.ptr)
 	b.ptr = nil
-	return reserr("Backup.Finish", "", "", res)
+	return b.dst.reserr("Backup.Finish", "", res)
 }
 
 // Remaining returns the number of pages still to be backed up at the
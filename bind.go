@@ -0,0 +1,35 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlite
+
+// Binder is implemented by types that bind themselves to a statement
+// parameter, typically by calling one of the Stmt Bind* methods.
+//
+// The reflection-based helpers in sqlitex, such as Exec, use
+// BindSQLite in preference to their own rules.
+type Binder interface {
+	BindSQLite(stmt *Stmt, param int) error
+}
+
+// Scanner is implemented by types that read themselves from a query
+// result column, typically by calling one of the Stmt Column* methods.
+// It is the read-side counterpart of Binder.
+//
+// The reflection-based helpers in sqlitex, such as Scan, use
+// ScanSQLite in preference to their own rules. ScanSQLite is called
+// for NULL columns too; use Stmt.ColumnType to detect them.
+type Scanner interface {
+	ScanSQLite(stmt *Stmt, col int) error
+}
@@ -35,7 +35,8 @@ see the documentation on Stmt.Step for details.
 
 The optional SQLite3 compiled in are: FTS5, RTree, JSON1, Session, GeoPoly
 
-This is not a database/sql driver.
+This is not a database/sql driver. For code that needs one, package
+crawshaw.io/sqlite/sqlitedriver provides a driver built on this package.
 
 
 Statement Caching
@@ -51,6 +52,10 @@ After all the connections in a pool have been warmed up by passing
 through one of these Prepare calls, subsequent calls are simply a
 map lookup that returns an existing statement.
 
+By default the cache is unbounded. Programs that build queries
+dynamically can bound it with SetStmtCacheCapacity, which evicts the
+least recently used statements that are not currently stepping.
+
 
 Streaming Blobs
 
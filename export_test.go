@@ -18,6 +18,8 @@ package sqlite
 
 func ConnCount(conn *Conn) int { return conn.count }
 
+func LogEnabled() bool { return logEnabled() }
+
 func InterruptedStmt(conn *Conn, query string) *Stmt {
 	return &Stmt{
 		conn:          conn,
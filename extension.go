@@ -37,7 +37,7 @@ func (conn *Conn) EnableLoadExtension(on bool) error {
 		enable = 1
 	}
 	res := C.db_config_onoff(conn.conn, SQLITE_DBCONFIG_ENABLE_LOAD_EXTENSION, enable)
-	return reserr("Conn.EnableLoadExtension", "", "", res)
+	return conn.reserr("Conn.EnableLoadExtension", "", res)
 }
 
 // LoadExtension attempts to load a runtime-loadable extension.
@@ -55,5 +55,5 @@ func (conn *Conn) LoadExtension(ext, entry string) error {
 	res := C.sqlite3_load_extension(conn.conn, cext, centry, &cerr)
 	err := C.GoString(cerr)
 	C.sqlite3_free(unsafe.Pointer(cerr))
-	return reserr("Conn.LoadExtension", "", err, res)
+	return conn.reserrmsg("Conn.LoadExtension", "", err, res)
 }
@@ -0,0 +1,109 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlite
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// A LogHandler receives messages from the SQLite error log.
+// The code is an extended result code. Most messages report errors,
+// but SQLITE_NOTICE and SQLITE_WARNING messages are informational.
+//
+// A LogHandler may be called from many goroutines at once, and must
+// not use the connection that caused the message.
+//
+// https://www.sqlite.org/errlog.html
+type LogHandler func(code ErrorCode, msg string)
+
+type logHandlerBox struct{ h LogHandler }
+
+var logHandler atomic.Value // of logHandlerBox
+
+// SetLogHandler directs the SQLite error log to h. A nil h discards
+// the log. Unlike Logger, SetLogHandler can be called at any time.
+func SetLogHandler(h LogHandler) {
+	logMu.Lock()
+	defer logMu.Unlock()
+	logHandler.Store(logHandlerBox{h})
+	updateLogging()
+}
+
+func loadLogHandler() LogHandler {
+	box, _ := logHandler.Load().(logHandlerBox)
+	return box.h
+}
+
+// FilterLog returns a LogHandler that passes to h only the messages
+// whose primary result code is one of codes. For example, to see
+// only messages about database corruption and I/O errors:
+//
+//	sqlite.SetLogHandler(sqlite.FilterLog(h, sqlite.SQLITE_CORRUPT, sqlite.SQLITE_IOERR))
+func FilterLog(h LogHandler, codes ...ErrorCode) LogHandler {
+	allow := make(map[ErrorCode]bool)
+	for _, code := range codes {
+		allow[code&0xff] = true
+	}
+	return func(code ErrorCode, msg string) {
+		if allow[code&0xff] {
+			h(code, msg)
+		}
+	}
+}
+
+// RateLimitLog returns a LogHandler that passes at most n messages
+// of each primary result code to h in each period. Messages over
+// the limit are dropped, and counted in the next message passed on
+// for the code. Limiting each code separately keeps a flood of, say,
+// SQLITE_NOTICE messages from hiding an SQLITE_CORRUPT.
+func RateLimitLog(h LogHandler, n int, period time.Duration) LogHandler {
+	type window struct {
+		start   time.Time
+		count   int
+		dropped int
+	}
+	var mu sync.Mutex
+	windows := make(map[ErrorCode]*window)
+	return func(code ErrorCode, msg string) {
+		now := time.Now()
+		mu.Lock()
+		w := windows[code&0xff]
+		if w == nil {
+			w = &window{start: now}
+			windows[code&0xff] = w
+		}
+		if now.Sub(w.start) >= period {
+			w.start = now
+			w.count = 0
+		}
+		if w.count >= n {
+			w.dropped++
+			mu.Unlock()
+			return
+		}
+		w.count++
+		dropped := w.dropped
+		w.dropped = 0
+		mu.Unlock()
+
+		if dropped > 0 {
+			msg = fmt.Sprintf("%s (%d earlier messages dropped)", msg, dropped)
+		}
+		h(code, msg)
+	}
+}
@@ -0,0 +1,51 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+//go:build go1.21
+// +build go1.21
+
+package sqlite
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogHandler returns a LogHandler that writes to logger at a level
+// chosen by LogLevel, with the result code as the "code" attribute.
+// The logger's own level then decides which messages are kept.
+func SlogHandler(logger *slog.Logger) LogHandler {
+	return func(code ErrorCode, msg string) {
+		logger.Log(context.Background(), LogLevel(code), msg, slog.String("code", code.String()))
+	}
+}
+
+// LogLevel reports the slog level of an SQLite log message with the
+// given result code:
+//
+//	SQLITE_NOTICE: slog.LevelInfo
+//	SQLITE_WARNING: slog.LevelWarn
+//	SQLITE_CORRUPT, SQLITE_IOERR, SQLITE_NOMEM, SQLITE_NOTADB,
+//	SQLITE_FULL, SQLITE_CANTOPEN, SQLITE_INTERNAL: slog.LevelError
+//	other errors, such as failed constraints: slog.LevelWarn
+func LogLevel(code ErrorCode) slog.Level {
+	switch code & 0xff {
+	case SQLITE_NOTICE:
+		return slog.LevelInfo
+	case SQLITE_CORRUPT, SQLITE_IOERR, SQLITE_NOMEM, SQLITE_NOTADB,
+		SQLITE_FULL, SQLITE_CANTOPEN, SQLITE_INTERNAL:
+		return slog.LevelError
+	}
+	return slog.LevelWarn
+}
@@ -0,0 +1,43 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+//go:build go1.21
+// +build go1.21
+
+package sqlite_test
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"crawshaw.io/sqlite"
+)
+
+func TestSlogHandler(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelError}))
+	h := sqlite.SlogHandler(logger)
+	h(sqlite.SQLITE_NOTICE_RECOVER_WAL, "recovered frames")
+	h(sqlite.SQLITE_WARNING_AUTOINDEX, "automatic index")
+	h(sqlite.SQLITE_CORRUPT_VTAB, "corrupt vtab")
+	out := buf.String()
+	if strings.Contains(out, "recovered") || strings.Contains(out, "automatic") {
+		t.Errorf("notice or warning logged at error level:\n%s", out)
+	}
+	if !strings.Contains(out, "corrupt vtab") || !strings.Contains(out, "code=SQLITE_CORRUPT_VTAB") {
+		t.Errorf("corruption not logged:\n%s", out)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlite_test
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+type logRecord struct {
+	code sqlite.ErrorCode
+	msg  string
+}
+
+type logRecorder struct {
+	mu      sync.Mutex
+	records []logRecord
+}
+
+func (r *logRecorder) handle(code sqlite.ErrorCode, msg string) {
+	r.mu.Lock()
+	r.records = append(r.records, logRecord{code, msg})
+	r.mu.Unlock()
+}
+
+func TestSetLogHandler(t *testing.T) {
+	c, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	r := new(logRecorder)
+	sqlite.SetLogHandler(r.handle)
+	defer sqlite.SetLogHandler(nil)
+
+	if _, err := c.Prepare("SELEC 1;"); err == nil {
+		t.Fatal("syntax error not reported")
+	}
+	if len(r.records) != 1 || r.records[0].code != sqlite.SQLITE_ERROR || !strings.Contains(r.records[0].msg, "syntax error") {
+		t.Errorf("log = %+v", r.records)
+	}
+
+	sqlite.SetLogHandler(nil)
+	c.Prepare("SELEC 2;")
+	if len(r.records) != 1 {
+		t.Errorf("log after SetLogHandler(nil) = %+v", r.records)
+	}
+	if sqlite.LogEnabled() {
+		t.Error("SQLite log still passed to Go with no handler")
+	}
+}
+
+func TestFilterLog(t *testing.T) {
+	r := new(logRecorder)
+	h := sqlite.FilterLog(r.handle, sqlite.SQLITE_CORRUPT)
+	h(sqlite.SQLITE_NOTICE_RECOVER_WAL, "recovered")
+	h(sqlite.SQLITE_CORRUPT_VTAB, "bad index")
+	if len(r.records) != 1 || r.records[0].msg != "bad index" {
+		t.Errorf("log = %+v", r.records)
+	}
+}
+
+func TestRateLimitLog(t *testing.T) {
+	r := new(logRecorder)
+	h := sqlite.RateLimitLog(r.handle, 2, 20*time.Millisecond)
+	for i := 0; i < 5; i++ {
+		h(sqlite.SQLITE_NOTICE, "notice")
+	}
+	h(sqlite.SQLITE_CORRUPT, "corrupt")
+	if len(r.records) != 3 || r.records[2].code != sqlite.SQLITE_CORRUPT {
+		t.Fatalf("log = %+v", r.records)
+	}
+	time.Sleep(25 * time.Millisecond)
+	h(sqlite.SQLITE_NOTICE, "notice")
+	if got, want := r.records[3].msg, "notice (3 earlier messages dropped)"; got != want {
+		t.Errorf("msg = %q, want %q", got, want)
+	}
+}
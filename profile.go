@@ -0,0 +1,157 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlite
+
+// #include <stdint.h>
+// #include <sqlite3.h>
+// extern int go_sqlite_trace_tramp(uintptr_t, unsigned int, void*, void*);
+// static int c_trace_tramp(unsigned int type, void *ctx, void *p, void *x) {
+//   return go_sqlite_trace_tramp((uintptr_t)ctx, type, p, x);
+// }
+// static int sqlite3_go_set_profiler(sqlite3* conn, uintptr_t id) {
+//   return sqlite3_trace_v2(conn, SQLITE_TRACE_STMT|SQLITE_TRACE_ROW|SQLITE_TRACE_PROFILE, c_trace_tramp, (void*)id);
+// }
+import "C"
+import (
+	"errors"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// StmtProfile describes one run of a statement, from its first Step
+// until it finishes or is reset.
+type StmtProfile struct {
+	Query    string        // the statement text, without bound values
+	Duration time.Duration // includes time the caller spent between Steps
+	Rows     int64         // rows returned
+
+	// Counters from https://www.sqlite.org/c3ref/c_stmtstatus_counter.html
+	FullScanSteps int64 // forward steps through a table in a full scan
+	Sorts         int64 // sort operations
+	AutoIndexes   int64 // rows inserted into automatic indexes
+}
+
+// SetProfiler registers fn to be called with the profile of each
+// statement run on the connection, using sqlite3_trace_v2.
+// SetProfiler(nil) removes the profiler.
+//
+// The profiler is called during Step or Reset. It must not use
+// the Conn.
+//
+// https://www.sqlite.org/c3ref/trace_v2.html
+func (conn *Conn) SetProfiler(fn func(StmtProfile)) error {
+	if fn == nil {
+		if conn.profiler == -1 {
+			return nil
+		}
+		conn.releaseProfiler()
+		res := C.sqlite3_trace_v2(conn.conn, 0, nil, nil)
+		return conn.reserr("SetProfiler", "", res)
+	}
+
+	profilers.mu.Lock()
+	id := profilers.next
+	next := profilers.next + 1
+	if next < 0 {
+		profilers.mu.Unlock()
+		return errors.New("sqlite: profiler id overflow")
+	}
+	profilers.next = next
+	profilers.m[id] = &profiler{fn: fn, runs: make(map[*C.sqlite3_stmt]*stmtRun)}
+	profilers.mu.Unlock()
+
+	res := C.sqlite3_go_set_profiler(conn.conn, C.uintptr_t(id))
+	if res != C.SQLITE_OK {
+		profilers.mu.Lock()
+		delete(profilers.m, id)
+		profilers.mu.Unlock()
+		return conn.reserr("SetProfiler", "", res)
+	}
+	conn.releaseProfiler()
+	conn.profiler = id
+	return nil
+}
+
+func (conn *Conn) releaseProfiler() {
+	if conn.profiler == -1 {
+		return
+	}
+	profilers.mu.Lock()
+	delete(profilers.m, conn.profiler)
+	profilers.mu.Unlock()
+	conn.profiler = -1
+}
+
+type profiler struct {
+	fn   func(StmtProfile)
+	runs map[*C.sqlite3_stmt]*stmtRun // statements that are running
+}
+
+type stmtRun struct {
+	start time.Time
+	rows  int64
+}
+
+var profilers = struct {
+	mu   sync.RWMutex
+	m    map[int]*profiler
+	next int
+}{
+	m: make(map[int]*profiler),
+}
+
+//export go_sqlite_trace_tramp
+func go_sqlite_trace_tramp(id uintptr, event C.uint, p, x unsafe.Pointer) C.int {
+	profilers.mu.RLock()
+	prof := profilers.m[int(id)]
+	profilers.mu.RUnlock()
+	if prof == nil {
+		return 0
+	}
+
+	// A profiler is only used by its connection, one goroutine at
+	// a time, so its runs map needs no lock.
+	//
+	// SQLite reports durations to the millisecond on most systems,
+	// so statements are timed here instead.
+	stmt := (*C.sqlite3_stmt)(p)
+	switch event {
+	case C.SQLITE_TRACE_STMT:
+		// Also reported as each trigger starts.
+		if prof.runs[stmt] == nil {
+			prof.runs[stmt] = &stmtRun{start: time.Now()}
+		}
+	case C.SQLITE_TRACE_ROW:
+		if run := prof.runs[stmt]; run != nil {
+			run.rows++
+		}
+	case C.SQLITE_TRACE_PROFILE:
+		run := prof.runs[stmt]
+		if run == nil {
+			return 0
+		}
+		delete(prof.runs, stmt)
+		prof.fn(StmtProfile{
+			Query:         C.GoString(C.sqlite3_sql(stmt)),
+			Duration:      time.Since(run.start),
+			Rows:          run.rows,
+			FullScanSteps: int64(C.sqlite3_stmt_status(stmt, C.SQLITE_STMTSTATUS_FULLSCAN_STEP, 1)),
+			Sorts:         int64(C.sqlite3_stmt_status(stmt, C.SQLITE_STMTSTATUS_SORT, 1)),
+			AutoIndexes:   int64(C.sqlite3_stmt_status(stmt, C.SQLITE_STMTSTATUS_AUTOINDEX, 1)),
+		})
+	}
+	return 0
+}
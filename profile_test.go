@@ -0,0 +1,69 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlite_test
+
+import (
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+func TestProfiler(t *testing.T) {
+	c, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var profiles []sqlite.StmtProfile
+	if err := c.SetProfiler(func(p sqlite.StmtProfile) { profiles = append(profiles, p) }); err != nil {
+		t.Fatal(err)
+	}
+	err = sqlitex.ExecScript(c, `CREATE TABLE t (c);
+		INSERT INTO t (c) VALUES (1), (2), (3);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	profiles = nil
+
+	stmt := c.Prep("SELECT c FROM t WHERE c > $min;")
+	stmt.SetInt64("$min", 1)
+	for {
+		if hasRow, err := stmt.Step(); err != nil {
+			t.Fatal(err)
+		} else if !hasRow {
+			break
+		}
+	}
+	if len(profiles) != 1 {
+		t.Fatalf("got %d profiles, want 1", len(profiles))
+	}
+	p := profiles[0]
+	if p.Query != "SELECT c FROM t WHERE c > $min;" || p.Rows != 2 || p.FullScanSteps != 2 || p.Duration <= 0 {
+		t.Errorf("profile = %+v", p)
+	}
+
+	if err := c.SetProfiler(nil); err != nil {
+		t.Fatal(err)
+	}
+	profiles = nil
+	if err := sqlitex.Exec(c, "SELECT count(*) FROM t;", nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 0 {
+		t.Errorf("got %d profiles after SetProfiler(nil)", len(profiles))
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlite
+
+// #include <sqlite3.h>
+// #include <stdlib.h>
+// #include <string.h>
+import "C"
+import (
+	"io"
+	"unsafe"
+)
+
+// Serialize writes an image of the schema database on conn to w, and
+// returns the number of bytes written. If schema is "", then "main" is
+// used.
+//
+// The image is taken in a read transaction, or in the transaction
+// conn is already in, so it is consistent. It is copied into memory
+// before it is written to w: the sqlite_dbpage table, which could read
+// it a page at a time, takes a write transaction.
+//
+// https://www.sqlite.org/c3ref/serialize.html
+func (conn *Conn) Serialize(schema string, w io.Writer) (int64, error) {
+	cschema, free := schemaCString(schema)
+	defer free()
+	var size C.sqlite3_int64
+	buf := C.sqlite3_serialize(conn.conn, cschema, &size, 0)
+	if buf == nil {
+		if size == 0 {
+			return 0, nil // an empty database
+		}
+		res := C.sqlite3_errcode(conn.conn)
+		if size > 0 {
+			res = C.SQLITE_NOMEM
+		} else if res == C.SQLITE_OK {
+			res = C.SQLITE_ERROR
+		}
+		return 0, conn.reserrmsg("Conn.Serialize", "", "cannot serialize database "+C.GoString(cschema), res)
+	}
+	defer C.sqlite3_free(unsafe.Pointer(buf))
+
+	chunk := make([]byte, 64<<10)
+	var off int64
+	for off < int64(size) {
+		n := int64(len(chunk))
+		if int64(size)-off < n {
+			n = int64(size) - off
+		}
+		C.memcpy(unsafe.Pointer(&chunk[0]), unsafe.Pointer(uintptr(unsafe.Pointer(buf))+uintptr(off)), C.size_t(n))
+		if _, err := w.Write(chunk[:n]); err != nil {
+			return off, err
+		}
+		off += n
+	}
+	return off, nil
+}
+
+// Deserialize replaces the schema database on conn with an in-memory
+// database holding the size bytes of a database image read from r.
+// If schema is "", then "main" is used.
+//
+// The image is read directly into memory owned by SQLite, which frees
+// it when the database is closed or detached. The database can grow
+// after it is deserialized.
+//
+// https://www.sqlite.org/c3ref/deserialize.html
+func (conn *Conn) Deserialize(schema string, r io.Reader, size int64) error {
+	if size < 0 {
+		return conn.reserrmsg("Conn.Deserialize", "", "negative size", C.SQLITE_MISUSE)
+	}
+	alloc := size
+	if alloc == 0 {
+		alloc = 1 // sqlite3_malloc64(0) returns NULL
+	}
+	buf := C.sqlite3_malloc64(C.sqlite3_uint64(alloc))
+	if buf == nil {
+		return conn.reserr("Conn.Deserialize", "", C.SQLITE_NOMEM)
+	}
+
+	chunk := make([]byte, 64<<10)
+	for off := int64(0); off < size; {
+		n := int64(len(chunk))
+		if size-off < n {
+			n = size - off
+		}
+		if _, err := io.ReadFull(r, chunk[:n]); err != nil {
+			C.sqlite3_free(buf)
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		C.memcpy(unsafe.Pointer(uintptr(buf)+uintptr(off)), unsafe.Pointer(&chunk[0]), C.size_t(n))
+		off += n
+	}
+
+	cschema, free := schemaCString(schema)
+	defer free()
+	// On failure SQLite frees buf itself, as SQLITE_DESERIALIZE_FREEONCLOSE is set.
+	res := C.sqlite3_deserialize(conn.conn, cschema, (*C.uchar)(buf), C.sqlite3_int64(size), C.sqlite3_int64(alloc),
+		C.SQLITE_DESERIALIZE_FREEONCLOSE|C.SQLITE_DESERIALIZE_RESIZEABLE)
+	return conn.extreserr("Conn.Deserialize", "", res)
+}
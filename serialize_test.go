@@ -0,0 +1,147 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlite_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+func TestSerialize(t *testing.T) {
+	src := initSrc(t)
+	defer src.Close()
+
+	var buf bytes.Buffer
+	n, err := src.Serialize("", &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) || n%512 != 0 {
+		t.Fatalf("Serialize returned %d, wrote %d bytes", n, buf.Len())
+	}
+	if _, err := src.Serialize("nosuchdb", &buf); err == nil {
+		t.Error("Serialize of an unknown database succeeded")
+	}
+
+	dst, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := dst.Deserialize("", bytes.NewReader(buf.Bytes()[:n-1]), n); err == nil {
+		t.Error("Deserialize of a short image succeeded")
+	}
+	if err := dst.Deserialize("main", bytes.NewReader(buf.Bytes()[:n]), n); err != nil {
+		t.Fatal(err)
+	}
+	if count, err := sqlitex.ResultInt(dst.Prep("SELECT count(*) FROM t;")); err != nil || count != 2 {
+		t.Errorf("count = %d, %v, want 2", count, err)
+	}
+	// The deserialized database can grow.
+	if err := sqlitex.Exec(dst, "INSERT INTO t (c1) SELECT randomblob(10000);", nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// writeFunc is an io.Writer that calls fn on its first Write.
+type writeFunc struct {
+	w  io.Writer
+	fn func()
+}
+
+func (w *writeFunc) Write(p []byte) (int, error) {
+	if w.fn != nil {
+		w.fn()
+		w.fn = nil
+	}
+	return w.w.Write(p)
+}
+
+func TestSerializeConcurrentWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite-serialize-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "db")
+
+	writer, err := sqlite.OpenConn(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+	err = sqlitex.ExecScript(writer, `CREATE TABLE t (c, pad);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < 50)
+		INSERT INTO t (c, pad) SELECT 'old', zeroblob(500) FROM n;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// With the WAL checkpointed, the reader reads only the database
+	// file, and does not stop the writer from restarting the WAL.
+	if err := sqlitex.Exec(writer, "PRAGMA wal_checkpoint(PASSIVE);", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := sqlite.OpenConn(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	if err := sqlitex.Exec(reader, "BEGIN;", nil); err != nil {
+		t.Fatal(err)
+	}
+	defer sqlitex.Exec(reader, "ROLLBACK;", nil)
+	if n, err := sqlitex.ResultInt(reader.Prep("SELECT count(*) FROM t;")); err != nil || n != 50 {
+		t.Fatalf("count = %d, %v, want 50", n, err)
+	}
+
+	var buf bytes.Buffer
+	w := &writeFunc{w: &buf, fn: func() {
+		for i := 0; i < 100; i++ {
+			if err := sqlitex.Exec(writer, "UPDATE t SET c = 'new';", nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}}
+	n, err := reader.Serialize("", w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	// An in-memory database cannot be in WAL mode.
+	img := buf.Bytes()
+	img[18], img[19] = 1, 1
+	if err := dst.Deserialize("", &buf, n); err != nil {
+		t.Fatal(err)
+	}
+	if old, err := sqlitex.ResultInt(dst.Prep("SELECT count(*) FROM t WHERE c = 'old';")); err != nil || old != 50 {
+		t.Errorf("image has %d old rows, %v, want 50", old, err)
+	}
+	if res, err := sqlitex.ResultText(dst.Prep("PRAGMA integrity_check;")); err != nil || res != "ok" {
+		t.Errorf("integrity_check = %q, %v", res, err)
+	}
+}
@@ -95,7 +95,7 @@ func (conn *Conn) GetSnapshot(schema string) (*Snapshot, func(), error) {
 	res := C.sqlite3_snapshot_get(conn.conn, s.schema, &s.ptr)
 	if res != 0 {
 		endRead()
-		return nil, nil, reserr("Conn.CreateSnapshot", "", "", res)
+		return nil, nil, conn.reserr("Conn.CreateSnapshot", "", res)
 	}
 
 	runtime.SetFinalizer(&s, func(s *Snapshot) {
@@ -167,7 +167,7 @@ func (conn *Conn) StartSnapshotRead(s *Snapshot) (endRead func(), err error) {
 	res := C.sqlite3_snapshot_open(conn.conn, s.schema, s.ptr)
 	if res != 0 {
 		endRead()
-		return nil, reserr("Conn.StartSnapshotRead", "", "", res)
+		return nil, conn.reserr("Conn.StartSnapshotRead", "", res)
 	}
 
 	return endRead, nil
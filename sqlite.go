@@ -53,8 +53,20 @@ package sqlite
 // }
 //
 // extern void log_fn(void* pArg, int code, char* msg);
+// static int log_enabled;
+// static void log_cb(void* pArg, int code, const char* msg) {
+//	if (__atomic_load_n(&log_enabled, __ATOMIC_RELAXED)) {
+//		log_fn(pArg, code, (char*)msg);
+//	}
+// }
 // static void enable_logging() {
-//	sqlite3_config(SQLITE_CONFIG_LOG, log_fn, NULL);
+//	sqlite3_config(SQLITE_CONFIG_LOG, log_cb, NULL);
+// }
+// static void set_log_enabled(int on) {
+//	__atomic_store_n(&log_enabled, on, __ATOMIC_RELAXED);
+// }
+// static int get_log_enabled() {
+//	return __atomic_load_n(&log_enabled, __ATOMIC_RELAXED);
 // }
 //
 // static int db_config_onoff(sqlite3* db, int op, int onoff) {
@@ -63,6 +75,7 @@ package sqlite
 import "C"
 import (
 	"bytes"
+	"container/list"
 	"runtime"
 	"sync"
 	"time"
@@ -75,7 +88,13 @@ import (
 type Conn struct {
 	conn       *C.sqlite3
 	stmts      map[string]*Stmt // query -> prepared statement
-	authorizer int              // authorizer ID or -1
+	stmtLRU    list.List        // of *Stmt in stmts, most recently used first
+	stmtCap    int              // maximum len(stmts), or 0 for unbounded
+	stmtStats  StmtCacheStats
+	authorizer int // authorizer ID or -1
+	profiler   int // profiler ID or -1
+	timeFormat TimeFormat
+	lastErr    ErrorCode // code of the most recent failed call
 	closed     bool
 	count      int // shared variable to help the race detector find Conn misuse
 
@@ -119,6 +138,7 @@ func openConn(path string, flags ...OpenFlags) (*Conn, error) {
 	conn := &Conn{
 		stmts:      make(map[string]*Stmt),
 		authorizer: -1,
+		profiler:   -1,
 		// A pointer to unlockNote is retained by C,
 		// so we allocate it on the C heap.
 		unlockNote: C.unlock_note_alloc(),
@@ -186,6 +206,7 @@ func (conn *Conn) Close() error {
 	C.unlock_note_free(conn.unlockNote)
 	conn.unlockNote = nil
 	conn.releaseAuthorizer()
+	conn.releaseProfiler()
 	return reserr("Conn.Close", "", "", res)
 }
 
@@ -193,6 +214,136 @@ func (conn *Conn) GetAutocommit() bool {
 	return int(C.sqlite3_get_autocommit(conn.conn)) != 0
 }
 
+// Closed reports whether Close has been called on the connection.
+func (conn *Conn) Closed() bool {
+	return conn.closed
+}
+
+// Filename returns the filename of the schema database, or the empty
+// string for temporary and in-memory databases.
+//
+// If schema is "", then a default of "main" is used.
+//
+// https://www.sqlite.org/c3ref/db_filename.html
+func (conn *Conn) Filename(schema string) string {
+	cschema, free := schemaCString(schema)
+	defer free()
+	return C.GoString(C.sqlite3_db_filename(conn.conn, cschema))
+}
+
+// ReadOnly reports whether the schema database is read-only.
+//
+// If schema is "", then a default of "main" is used.
+// If schema is not the name of a database on conn, ReadOnly reports false.
+//
+// https://www.sqlite.org/c3ref/db_readonly.html
+func (conn *Conn) ReadOnly(schema string) bool {
+	cschema, free := schemaCString(schema)
+	defer free()
+	return C.sqlite3_db_readonly(conn.conn, cschema) == 1
+}
+
+// TxnState is the transaction state of a database, as reported by
+// sqlite3_txn_state.
+//
+// https://www.sqlite.org/c3ref/c_txn_none.html
+type TxnState int
+
+const (
+	SQLITE_TXN_NONE  = TxnState(C.SQLITE_TXN_NONE)
+	SQLITE_TXN_READ  = TxnState(C.SQLITE_TXN_READ)
+	SQLITE_TXN_WRITE = TxnState(C.SQLITE_TXN_WRITE)
+)
+
+// String returns the state's C constant name.
+func (state TxnState) String() string {
+	switch state {
+	default:
+		var buf [20]byte
+		return "SQLITE_UNKNOWN_TXN_STATE(" + string(itoa(buf[:], int64(state))) + ")"
+	case SQLITE_TXN_NONE:
+		return "SQLITE_TXN_NONE"
+	case SQLITE_TXN_READ:
+		return "SQLITE_TXN_READ"
+	case SQLITE_TXN_WRITE:
+		return "SQLITE_TXN_WRITE"
+	}
+}
+
+// TxnState reports the transaction state of the schema database.
+//
+// If schema is "", the highest transaction state of any database
+// on the connection is reported. This is the way to ask whether a
+// connection is holding any lock at all.
+// If schema is not the name of a database on conn, SQLITE_TXN_NONE
+// is reported.
+//
+// https://www.sqlite.org/c3ref/txn_state.html
+func (conn *Conn) TxnState(schema string) TxnState {
+	var cschema *C.char
+	if schema != "" {
+		var free func()
+		cschema, free = schemaCString(schema)
+		defer free()
+	}
+	state := TxnState(C.sqlite3_txn_state(conn.conn, cschema))
+	if state < 0 {
+		return SQLITE_TXN_NONE
+	}
+	return state
+}
+
+// DatabaseInfo describes a database attached to a connection.
+type DatabaseInfo struct {
+	Name     string // schema name: "main", "temp", or the ATTACH name
+	File     string // filename, or "" for temporary and in-memory databases
+	ReadOnly bool
+}
+
+// Databases reports the databases attached to the connection,
+// including main and temp, in the order reported by
+// PRAGMA database_list.
+//
+// https://www.sqlite.org/pragma.html#pragma_database_list
+func (conn *Conn) Databases() ([]DatabaseInfo, error) {
+	stmt, _, err := conn.PrepareTransient("PRAGMA database_list;")
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Finalize()
+
+	var dbs []DatabaseInfo
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, err
+		}
+		if !hasRow {
+			break
+		}
+		name := stmt.ColumnText(1)
+		dbs = append(dbs, DatabaseInfo{
+			Name:     name,
+			File:     stmt.ColumnText(2),
+			ReadOnly: conn.ReadOnly(name),
+		})
+	}
+	return dbs, nil
+}
+
+// schemaCString returns a C string for the schema name.
+// The returned func frees any memory allocated for it.
+func schemaCString(schema string) (*C.char, func()) {
+	switch schema {
+	case "", "main":
+		return cmain, func() {}
+	case "temp":
+		return ctemp, func() {}
+	}
+	cschema := C.CString(schema)
+	return cschema, func() { C.free(unsafe.Pointer(cschema)) }
+}
+
 const (
 	SQLITE_DBCONFIG_DQS_DML = C.int(C.SQLITE_DBCONFIG_DQS_DML)
 	SQLITE_DBCONFIG_DQS_DDL = C.int(C.SQLITE_DBCONFIG_DQS_DDL)
@@ -212,7 +363,7 @@ func (conn *Conn) EnableDoubleQuotedStringLiterals(dml, ddl bool) error {
 	}
 	res := C.db_config_onoff(conn.conn, SQLITE_DBCONFIG_DQS_DML, enable)
 	if res != 0 {
-		return reserr("Conn.EnableDoubleQuotedStringLiterals", "", "", res)
+		return conn.reserr("Conn.EnableDoubleQuotedStringLiterals", "", res)
 	}
 	enable = 0
 	if ddl {
@@ -220,7 +371,7 @@ func (conn *Conn) EnableDoubleQuotedStringLiterals(dml, ddl bool) error {
 	}
 	res = C.db_config_onoff(conn.conn, SQLITE_DBCONFIG_DQS_DDL, enable)
 	if res != 0 {
-		return reserr("Conn.EnableDoubleQuotedStringLiterals", "", "", res)
+		return conn.reserr("Conn.EnableDoubleQuotedStringLiterals", "", res)
 	}
 	return nil
 }
@@ -283,7 +434,7 @@ func (conn *Conn) SetInterrupt(doneCh <-chan struct{}) (oldDoneCh <-chan struct{
 	conn.doneCh = doneCh
 	for _, stmt := range conn.stmts {
 		if stmt.lastHasRow {
-			stmt.Reset()
+			stmt.reset()
 		}
 	}
 	if doneCh == nil {
@@ -318,7 +469,7 @@ func (conn *Conn) SetBusyTimeout(d time.Duration) {
 func (conn *Conn) interrupted(loc, query string) error {
 	select {
 	case <-conn.doneCh:
-		return reserr(loc, query, "", C.SQLITE_INTERRUPT)
+		return conn.reserr(loc, query, C.SQLITE_INTERRUPT)
 	default:
 		return nil
 	}
@@ -369,12 +520,15 @@ func (conn *Conn) Prep(query string) *Stmt {
 // https://www.sqlite.org/c3ref/prepare.html
 func (conn *Conn) Prepare(query string) (*Stmt, error) {
 	if stmt := conn.stmts[query]; stmt != nil {
+		conn.stmtStats.Hits++
+		conn.stmtLRU.MoveToFront(stmt.lruElem)
 		if err := stmt.Reset(); err != nil {
 			return nil, err
 		}
 		if err := stmt.ClearBindings(); err != nil {
 			return nil, err
 		}
+		stmt.checkedOut = true
 		if conn.tracer != nil {
 			// TODO: is query too long for a task name?
 			//       should we use trace.Log instead?
@@ -388,15 +542,94 @@ func (conn *Conn) Prepare(query string) (*Stmt, error) {
 	}
 	if trailingBytes != 0 {
 		stmt.Finalize()
-		return nil, reserr("Conn.Prepare", query, "statement has trailing bytes", C.SQLITE_ERROR)
+		return nil, conn.reserrmsg("Conn.Prepare", query, "statement has trailing bytes", C.SQLITE_ERROR)
 	}
+	conn.stmtStats.Misses++
 	conn.stmts[query] = stmt
+	stmt.lruElem = conn.stmtLRU.PushFront(stmt)
+	stmt.checkedOut = true
+	conn.evictStmts()
 	if conn.tracer != nil {
 		stmt.tracerTask = conn.tracer.NewTask(query)
 	}
 	return stmt, nil
 }
 
+// StmtCacheStats reports on the use of the persistent prepared
+// statement cache of a Conn.
+type StmtCacheStats struct {
+	Size      int   // number of cached statements
+	Capacity  int   // maximum number of cached statements, 0 if unbounded
+	Hits      int64 // Prepare calls that returned a cached statement
+	Misses    int64 // Prepare calls that prepared a new statement
+	Evictions int64 // statements finalized to stay within Capacity
+}
+
+// SetStmtCacheCapacity limits the number of persistent prepared
+// statements cached by Prepare to n. A value of 0 or less, the
+// default, means the cache is unbounded.
+//
+// When the cache is full, Prepare finalizes the least recently
+// prepared statement that is not in use. A Stmt is in use from when
+// it is returned by Prepare or stepped until it is Reset, so the
+// statement being returned, and statements that are bound, stepping
+// or done but not yet Reset, are never evicted. The cache can then
+// temporarily hold more than n statements.
+//
+// An evicted Stmt must not be used again. Code that holds several
+// Stmts from Prep or Prepare while preparing others, and Resets them
+// in between (for example, a BEGIN and COMMIT pair around a loop),
+// needs a capacity large enough to keep all of them cached at once.
+func (conn *Conn) SetStmtCacheCapacity(n int) {
+	if n < 0 {
+		n = 0
+	}
+	conn.stmtCap = n
+	conn.evictStmts()
+}
+
+// StmtCacheStats reports statistics for the persistent prepared
+// statement cache.
+func (conn *Conn) StmtCacheStats() StmtCacheStats {
+	stats := conn.stmtStats
+	stats.Size = len(conn.stmts)
+	stats.Capacity = conn.stmtCap
+	return stats
+}
+
+// FinalizeCached finalizes all persistent prepared statements
+// cached by the Conn. Any Stmt previously returned by Prep or
+// Prepare must not be used after this call.
+//
+// This is useful for draining a long-lived connection that has
+// accumulated statements. The cache statistics are retained.
+func (conn *Conn) FinalizeCached() (err error) {
+	for _, stmt := range conn.stmts {
+		if ferr := stmt.Finalize(); err == nil {
+			err = ferr
+		}
+	}
+	return err
+}
+
+// evictStmts finalizes least recently used statements until
+// the statement cache is within its capacity.
+func (conn *Conn) evictStmts() {
+	if conn.stmtCap == 0 {
+		return
+	}
+	e := conn.stmtLRU.Back()
+	for len(conn.stmts) > conn.stmtCap && e != nil {
+		stmt := e.Value.(*Stmt)
+		e = e.Prev()
+		if stmt.checkedOut {
+			continue
+		}
+		stmt.Finalize()
+		conn.stmtStats.Evictions++
+	}
+}
+
 // PrepareTransient prepares an SQL statement that is not cached by
 // the Conn. Subsequent calls with the same query will create new Stmts.
 // Finalize must be called by the caller once done with the Stmt.
@@ -477,6 +710,19 @@ func (conn *Conn) LastInsertRowID() int64 {
 	return int64(C.sqlite3_last_insert_rowid(conn.conn))
 }
 
+// LastErrorCode reports the result code of the most recent call on
+// the Conn, or on one of its Stmts, Blobs or Backups, that failed, or
+// SQLITE_OK if none has. It is not cleared by later successful calls,
+// so it can be used after the fact to tell whether a connection has
+// seen an error such as SQLITE_CORRUPT or SQLITE_IOERR.
+//
+// Errors that do not come from SQLite's view of the connection are not
+// recorded: those of Stmt.ColumnTime, of Blob.Seek, of Blob calls
+// after Close, and of Session, Changegroup and ChangesetIter methods.
+func (conn *Conn) LastErrorCode() ErrorCode {
+	return conn.lastErr
+}
+
 // extreserr asks SQLite for a string explaining the error.
 // Only called for errors that are probably program bugs.
 func (conn *Conn) extreserr(loc, query string, res C.int) error {
@@ -487,20 +733,27 @@ func (conn *Conn) extreserr(loc, query string, res C.int) error {
 	default:
 		msg = C.GoString(C.sqlite3_errmsg(conn.conn))
 	}
-	return reserr(loc, query, msg, res)
+	return conn.reserrmsg(loc, query, msg, res)
 }
 
 func (conn *Conn) reserr(loc, query string, res C.int) error {
-	switch res {
-	case C.SQLITE_OK, C.SQLITE_ROW, C.SQLITE_DONE:
-		return nil
-	}
 	// TODO
 	/*extres := C.sqlite3_extended_errcode(conn.conn)
 	if extres != 0 {
 		res = extres
 	}*/
-	return reserr(loc, query, "", res)
+	return conn.reserrmsg(loc, query, "", res)
+}
+
+// reserrmsg is reserr with a message explaining the error.
+// It records res as the LastErrorCode of conn.
+func (conn *Conn) reserrmsg(loc, query, msg string, res C.int) error {
+	switch res {
+	case C.SQLITE_OK, C.SQLITE_ROW, C.SQLITE_DONE:
+		return nil
+	}
+	conn.lastErr = ErrorCode(res)
+	return reserr(loc, query, msg, res)
 }
 
 func reserr(loc, query, msg string, res C.int) error {
@@ -533,12 +786,14 @@ type Stmt struct {
 	prepInterrupt bool // set if Prep was interrupted
 	lastHasRow    bool // last bool returned by Step
 	tracerTask    TracerTask
+	lruElem       *list.Element // element in conn.stmtLRU, if cached
+	checkedOut    bool          // in use since Prepare or Step, until Reset
 }
 
 func (stmt *Stmt) interrupted(loc string) error {
 	loc = "Stmt." + loc
 	if stmt.prepInterrupt {
-		return reserr(loc, stmt.query, "", C.SQLITE_INTERRUPT)
+		return stmt.conn.reserr(loc, stmt.query, C.SQLITE_INTERRUPT)
 	}
 	return stmt.conn.interrupted(loc, stmt.query)
 }
@@ -556,10 +811,13 @@ func (stmt *Stmt) Finalize() error {
 	stmt.conn.count++
 	if ptr := stmt.conn.stmts[stmt.query]; ptr == stmt {
 		delete(stmt.conn.stmts, stmt.query)
+		stmt.conn.stmtLRU.Remove(stmt.lruElem)
+		stmt.lruElem = nil
 	}
 	res := C.sqlite3_finalize(stmt.stmt)
+	conn := stmt.conn
 	stmt.conn = nil
-	return stmt.conn.reserr("Stmt.Finalize", stmt.query, res)
+	return conn.reserr("Stmt.Finalize", stmt.query, res)
 }
 
 // Reset resets a prepared statement so it can be executed again.
@@ -567,8 +825,18 @@ func (stmt *Stmt) Finalize() error {
 // Note that any parameter values bound to the statement are retained.
 // To clear bound values, call ClearBindings.
 //
+// A statement cached by Prepare is no longer in use once it is Reset,
+// and may be evicted from a bounded cache. See SetStmtCacheCapacity.
+//
 // https://www.sqlite.org/c3ref/reset.html
 func (stmt *Stmt) Reset() error {
+	stmt.checkedOut = false
+	return stmt.reset()
+}
+
+// reset is Reset for use inside the package, where the caller may
+// still hold the Stmt, so it remains in use.
+func (stmt *Stmt) reset() error {
 	stmt.conn.count++
 	stmt.lastHasRow = false
 	var res C.int
@@ -595,6 +863,9 @@ func (stmt *Stmt) ClearBindings() error {
 	if err := stmt.interrupted("ClearBindings"); err != nil {
 		return err
 	}
+	if stmt.stmt == nil {
+		return nil
+	}
 	res := C.sqlite3_clear_bindings(stmt.stmt)
 	return stmt.conn.reserr("Stmt.ClearBindings", stmt.query, res)
 }
@@ -633,10 +904,11 @@ func (stmt *Stmt) ClearBindings() error {
 //
 //	http://www.sqlite.org/unlock_notify.html
 func (stmt *Stmt) Step() (rowReturned bool, err error) {
+	stmt.checkedOut = true
 	if stmt.bindErr != nil {
 		err = stmt.bindErr
 		stmt.bindErr = nil
-		stmt.Reset()
+		stmt.reset()
 		return false, err
 	}
 
@@ -664,6 +936,10 @@ func (stmt *Stmt) step() (bool, error) {
 		if err := stmt.interrupted("Step"); err != nil {
 			return false, err
 		}
+		if stmt.stmt == nil {
+			// An empty query, such as a comment, has no rows.
+			return false, nil
+		}
 		switch res := C.sqlite3_step(stmt.stmt); uint8(res) { // reduce to non-extended error code
 		case C.SQLITE_LOCKED:
 			if res != C.SQLITE_LOCKED_SHAREDCACHE {
@@ -699,7 +975,7 @@ func (stmt *Stmt) handleBindErr(loc string, res C.int) {
 func (stmt *Stmt) findBindName(loc string, param string) int {
 	pos := stmt.bindIndex[param]
 	if pos == 0 && stmt.bindErr == nil {
-		stmt.bindErr = reserr("Stmt."+loc, stmt.query, "unknown parameter: "+param, C.SQLITE_ERROR)
+		stmt.bindErr = stmt.conn.reserrmsg("Stmt."+loc, stmt.query, "unknown parameter: "+param, C.SQLITE_ERROR)
 	}
 	return pos
 }
@@ -1073,6 +1349,15 @@ func (stmt *Stmt) ColumnTableName(col int) string {
 	return C.GoString((*C.char)(unsafe.Pointer(C.sqlite3_column_table_name(stmt.stmt, C.int(col)))))
 }
 
+// ColumnDeclType returns the declared type of the table column that a
+// result column comes from, such as "INTEGER" or "DATETIME". It is ""
+// for an expression or a column declared without a type.
+//
+// https://sqlite.org/c3ref/column_decltype.html
+func (stmt *Stmt) ColumnDeclType(col int) string {
+	return C.GoString(C.sqlite3_column_decltype(stmt.stmt, C.int(col)))
+}
+
 // ColumnIndex returns the index of the column with the given name.
 //
 // If there is no column with the given name ColumnIndex returns -1.
@@ -1219,24 +1504,58 @@ var (
 )
 
 func sqliteInitFn() {
-	if Logger != nil {
-		C.enable_logging()
+	// SQLite only accepts a log callback before it is initialized,
+	// so one is installed now and switched on by updateLogging, to
+	// let SetLogHandler work at any time.
+	logMu.Lock()
+	defer logMu.Unlock()
+	logger = Logger
+	C.enable_logging()
+	updateLogging()
+}
+
+var (
+	logMu  sync.Mutex
+	logger func(code ErrorCode, msg []byte) // Logger as of the first OpenConn
+)
+
+// updateLogging passes the SQLite log to Go only while there is a
+// Logger or LogHandler to receive it.
+// It is called with logMu held.
+func updateLogging() {
+	on := C.int(0)
+	if logger != nil || loadLogHandler() != nil {
+		on = 1
 	}
+	C.set_log_enabled(on)
+}
+
+// logEnabled reports whether the SQLite log is passed to Go.
+func logEnabled() bool {
+	return C.get_log_enabled() != 0
 }
 
 //export log_fn
 func log_fn(_ unsafe.Pointer, code C.int, msg *C.char) {
-	var msgBytes []byte
-	if msg != nil {
-		str := C.GoString(msg) // TODO: do not copy msg.
-		msgBytes = []byte(str)
+	if logger != nil {
+		var msgBytes []byte
+		if msg != nil {
+			n := int(C.strlen(msg))
+			msgBytes = (*[1 << 30]byte)(unsafe.Pointer(msg))[:n:n]
+		}
+		logger(ErrorCode(code), msgBytes)
+	}
+	if h := loadLogHandler(); h != nil {
+		h(ErrorCode(code), C.GoString(msg))
 	}
-	Logger(ErrorCode(code), msgBytes)
 }
 
 // Logger is written to by SQLite.
-// The Logger must be set before any connection is opened.
+// The Logger must be set before the first connection is opened;
+// later changes are ignored.
 // The msg slice is only valid for the duration of the call.
 //
 // It is very noisy.
+//
+// Deprecated: Use SetLogHandler, which is safe to change at any time.
 var Logger func(code ErrorCode, msg []byte)
@@ -539,6 +539,40 @@ func TestExtendedCodes(t *testing.T) {
 	if got, want := sqlite.ErrCode(err), sqlite.SQLITE_CONSTRAINT_UNIQUE; got != want {
 		t.Errorf("got err=%s, want %s", got, want)
 	}
+	stmt.Reset()
+	if got, want := c.LastErrorCode(), sqlite.SQLITE_CONSTRAINT_UNIQUE; got != want {
+		t.Errorf("LastErrorCode=%s, want %s", got, want)
+	}
+}
+
+func TestLastErrorCode(t *testing.T) {
+	c, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	if code := c.LastErrorCode(); code != sqlite.SQLITE_OK {
+		t.Errorf("LastErrorCode of a new Conn = %s, want SQLITE_OK", code)
+	}
+
+	if _, err := c.Prepare("SELECT 1; SELECT 2;"); err == nil {
+		t.Fatal("Prepare with trailing bytes did not fail")
+	}
+	if code := c.LastErrorCode(); code != sqlite.SQLITE_ERROR {
+		t.Errorf("LastErrorCode after trailing bytes = %s, want SQLITE_ERROR", code)
+	}
+
+	done := make(chan struct{})
+	close(done)
+	c.SetInterrupt(done)
+	if _, err := c.Prepare("SELECT 3;"); sqlite.ErrCode(err) != sqlite.SQLITE_INTERRUPT {
+		t.Fatalf("Prepare err = %v, want SQLITE_INTERRUPT", err)
+	}
+	c.SetInterrupt(nil)
+	if code := c.LastErrorCode(); code != sqlite.SQLITE_INTERRUPT {
+		t.Errorf("LastErrorCode after interrupt = %s, want SQLITE_INTERRUPT", code)
+	}
 }
 
 type errWithMessage struct {
@@ -864,3 +898,203 @@ func TestReturningClause(t *testing.T) {
 		t.Fatalf("want returned fruit id to be 1, got %d", id)
 	}
 }
+
+func TestConnState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlite-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbFile := filepath.Join(dir, "main.db")
+	c, err := sqlite.OpenConn(dbFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			t.Error(err)
+		}
+		if !c.Closed() {
+			t.Error("Closed reports false after Close")
+		}
+	}()
+	if c.Closed() {
+		t.Error("Closed reports true on open connection")
+	}
+
+	if got := c.Filename(""); got != dbFile {
+		t.Errorf("Filename(\"\") = %q, want %q", got, dbFile)
+	}
+	if c.ReadOnly("main") {
+		t.Error("main is read-only")
+	}
+
+	otherFile := filepath.Join(dir, "other.db")
+	if err := sqlitex.ExecTransient(c, "ATTACH DATABASE ? AS other;", nil, otherFile); err != nil {
+		t.Fatal(err)
+	}
+
+	dbs, err := c.Databases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []sqlite.DatabaseInfo{
+		{Name: "main", File: dbFile},
+		{Name: "other", File: otherFile},
+	}
+	if !reflect.DeepEqual(dbs, want) {
+		t.Errorf("Databases() = %+v, want %+v", dbs, want)
+	}
+
+	if got := c.TxnState(""); got != sqlite.SQLITE_TXN_NONE {
+		t.Errorf("TxnState before BEGIN = %v", got)
+	}
+	if err := sqlitex.ExecScript(c, "CREATE TABLE other.t (c);"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlitex.Exec(c, "BEGIN;", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlitex.Exec(c, "SELECT count(*) FROM other.t;", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.TxnState("other"); got != sqlite.SQLITE_TXN_READ {
+		t.Errorf("TxnState(other) after SELECT = %v, want SQLITE_TXN_READ", got)
+	}
+	if got := c.TxnState("main"); got != sqlite.SQLITE_TXN_NONE {
+		t.Errorf("TxnState(main) after SELECT = %v, want SQLITE_TXN_NONE", got)
+	}
+	if err := sqlitex.Exec(c, "INSERT INTO other.t (c) VALUES (1);", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.TxnState(""); got != sqlite.SQLITE_TXN_WRITE {
+		t.Errorf("TxnState(\"\") after INSERT = %v, want SQLITE_TXN_WRITE", got)
+	}
+	if got := c.TxnState("nosuchdb"); got != sqlite.SQLITE_TXN_NONE {
+		t.Errorf("TxnState(nosuchdb) = %v, want SQLITE_TXN_NONE", got)
+	}
+	if err := sqlitex.Exec(c, "COMMIT;", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.TxnState(""); got != sqlite.SQLITE_TXN_NONE {
+		t.Errorf("TxnState after COMMIT = %v", got)
+	}
+}
+
+func TestStmtCache(t *testing.T) {
+	c, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := c.Close(); err != nil {
+			t.Error(err)
+		}
+	}()
+	c.SetStmtCacheCapacity(2)
+
+	busy := c.Prep("SELECT 1 UNION ALL SELECT 2;")
+	if hasRow, err := busy.Step(); err != nil {
+		t.Fatal(err)
+	} else if !hasRow {
+		t.Fatal("no row")
+	}
+
+	for i := 0; i < 5; i++ {
+		stmt := c.Prep("SELECT " + strings.Repeat("1+", i) + "1;")
+		if _, err := stmt.Step(); err != nil {
+			t.Fatal(err)
+		}
+		if err := stmt.Reset(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Prep("SELECT 1;") // evicted, prepared again
+
+	stats := c.StmtCacheStats()
+	want := sqlite.StmtCacheStats{Size: 2, Capacity: 2, Hits: 0, Misses: 7, Evictions: 5}
+	if stats != want {
+		t.Errorf("StmtCacheStats() = %+v, want %+v", stats, want)
+	}
+
+	// The busy statement was never evicted.
+	if hasRow, err := busy.Step(); err != nil {
+		t.Fatal(err)
+	} else if !hasRow {
+		t.Fatal("busy statement lost its second row")
+	}
+	if got := busy.ColumnInt(0); got != 2 {
+		t.Errorf("busy statement second row = %d, want 2", got)
+	}
+	busy.Reset()
+
+	c.Prep("SELECT 1;")
+	if got := c.StmtCacheStats().Hits; got != 1 {
+		t.Errorf("Hits = %d, want 1", got)
+	}
+
+	if err := c.FinalizeCached(); err != nil {
+		t.Fatal(err)
+	}
+	if got := c.StmtCacheStats().Size; got != 0 {
+		t.Errorf("Size after FinalizeCached = %d, want 0", got)
+	}
+	if got := c.CheckReset(); got != "" {
+		t.Errorf("CheckReset after FinalizeCached = %q", got)
+	}
+}
+
+func TestStmtCacheInUse(t *testing.T) {
+	c, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetStmtCacheCapacity(1)
+
+	busy := c.Prep("SELECT 1 UNION ALL SELECT 2;")
+	if _, err := busy.Step(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The statement being returned is not evicted, even though the
+	// only other cached statement is busy.
+	if _, err := c.Prep("SELECT 3;").Step(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Statements that are bound but not stepped, or done but not
+	// Reset, are not evicted.
+	bound := c.Prep("SELECT $v;")
+	bound.SetInt64("$v", 4)
+	done := c.Prep("SELECT 5;")
+	if hasRow, err := done.Step(); err != nil || !hasRow {
+		t.Fatalf("done.Step() = %v, %v", hasRow, err)
+	}
+	if hasRow, err := done.Step(); err != nil || hasRow {
+		t.Fatalf("done.Step() = %v, %v", hasRow, err)
+	}
+	c.Prep("SELECT 6;")
+	if hasRow, err := bound.Step(); err != nil || !hasRow {
+		t.Fatalf("bound.Step() = %v, %v", hasRow, err)
+	}
+	if got := bound.ColumnInt(0); got != 4 {
+		t.Errorf("bound statement returned %d, want 4", got)
+	}
+	if err := done.Reset(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Once Reset, statements are evicted again.
+	for _, stmt := range []*sqlite.Stmt{busy, bound, done} {
+		if err := stmt.Reset(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// SELECT 3, 6 and 7 are still in use.
+	c.Prep("SELECT 7;")
+	if got := c.StmtCacheStats().Size; got != 3 {
+		t.Errorf("cache size after Reset = %d, want 3", got)
+	}
+}
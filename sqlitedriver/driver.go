@@ -0,0 +1,606 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+// Package sqlitedriver is a database/sql driver built on package sqlite.
+//
+// It exists for code that only speaks database/sql, such as migration
+// tools and query builders. Programs written for SQLite should use
+// package sqlite and sqlitex directly.
+//
+// Importing the package registers the driver under DriverName:
+//
+//	import _ "crawshaw.io/sqlite/sqlitedriver"
+//
+//	db, err := sql.Open("crawshaw.io/sqlite", "file:app.db")
+//
+// The data source name is passed to sqlite.OpenConn with the default
+// flags. To choose flags, use NewConnector with sql.OpenDB.
+//
+// Context cancellation is implemented with Conn.SetInterrupt.
+// Arguments may be positional or named with sql.Named; a name matches
+// the query parameter with any of the prefixes :, @ and $.
+// Transactions begin with BEGIN, or with a SAVEPOINT if the Conn is
+// already in a transaction.
+// A time.Time argument is bound with Stmt.BindTime, and values of
+// columns declared DATE, DATETIME or TIMESTAMP are read back with
+// Stmt.ColumnTime, so they Scan into a time.Time.
+//
+// The underlying *sqlite.Conn is available through sql.Conn.Raw:
+//
+//	err := sqlConn.Raw(func(driverConn interface{}) error {
+//		conn := driverConn.(*sqlitedriver.Conn).SQLiteConn()
+//		// ... use conn
+//		return nil
+//	})
+package sqlitedriver // import "crawshaw.io/sqlite/sqlitedriver"
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// DriverName is the name the driver is registered under with
+// database/sql.
+const DriverName = "crawshaw.io/sqlite"
+
+func init() {
+	sql.Register(DriverName, &Driver{})
+}
+
+// Driver is the database/sql driver.
+type Driver struct {
+	// Flags are passed to sqlite.OpenConn.
+	// Zero means sqlite.OpenFlagsDefault.
+	Flags sqlite.OpenFlags
+}
+
+// Open opens a connection to the database named by uri.
+func (d *Driver) Open(uri string) (driver.Conn, error) {
+	return NewConnector(uri, d.Flags).Connect(context.Background())
+}
+
+// OpenConnector returns a connector for the database named by uri.
+func (d *Driver) OpenConnector(uri string) (driver.Connector, error) {
+	return NewConnector(uri, d.Flags), nil
+}
+
+// NewConnector returns a connector that opens uri with flags,
+// for use with sql.OpenDB.
+func NewConnector(uri string, flags sqlite.OpenFlags) driver.Connector {
+	return &connector{uri: uri, flags: flags}
+}
+
+type connector struct {
+	uri   string
+	flags sqlite.OpenFlags
+}
+
+func (c *connector) Connect(ctx context.Context) (driver.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	conn, err := sqlite.OpenConn(c.uri, c.flags)
+	if err != nil {
+		return nil, err
+	}
+	return &Conn{conn: conn}, nil
+}
+
+func (c *connector) Driver() driver.Driver {
+	return &Driver{Flags: c.flags}
+}
+
+// Conn is a database/sql driver connection.
+//
+// It implements the context-aware optional interfaces of
+// package database/sql/driver.
+type Conn struct {
+	conn *sqlite.Conn
+	tx   *tx
+}
+
+// SQLiteConn returns the underlying connection.
+//
+// The connection must not be used outside of the function passed to
+// sql.Conn.Raw, and must be left as it was found: in particular, it
+// must not be closed and any transaction it began must be ended.
+func (c *Conn) SQLiteConn() *sqlite.Conn {
+	return c.conn
+}
+
+// interrupt arranges for operations on c to be interrupted when ctx is
+// done. It returns a function that restores the previous interrupt.
+func (c *Conn) interrupt(ctx context.Context) (restore func(), err error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	done := ctx.Done()
+	if done == nil {
+		return func() {}, nil
+	}
+	old := c.conn.SetInterrupt(done)
+	return func() { c.conn.SetInterrupt(old) }, nil
+}
+
+// ctxErr returns the context error for an interrupted operation.
+func ctxErr(ctx context.Context, err error) error {
+	if sqlite.ErrCode(err) == sqlite.SQLITE_INTERRUPT && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
+
+// ResetSession reports driver.ErrBadConn if the connection cannot be
+// reused.
+func (c *Conn) ResetSession(ctx context.Context) error {
+	if c.conn.Closed() {
+		return driver.ErrBadConn
+	}
+	return nil
+}
+
+// Prepare prepares a statement.
+func (c *Conn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext prepares a statement.
+//
+// Statements are prepared with PrepareTransient, so they are not
+// shared with the statement cache of the underlying connection.
+func (c *Conn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	restore, err := c.interrupt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	stmt, trailingBytes, err := c.conn.PrepareTransient(query)
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	if strings.TrimSpace(query[len(query)-trailingBytes:]) != "" {
+		stmt.Finalize()
+		return nil, fmt.Errorf("sqlitedriver: query %q has trailing bytes", query)
+	}
+	return &stmtWrapper{conn: c, stmt: stmt}, nil
+}
+
+// ExecContext executes a query.
+//
+// A query with no args may contain several statements, which are
+// executed in turn. The result reports the last of them.
+func (c *Conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	restore, err := c.interrupt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+
+	for {
+		stmt, trailingBytes, err := c.conn.PrepareTransient(query)
+		if err != nil {
+			return nil, ctxErr(ctx, err)
+		}
+		rest := strings.TrimSpace(query[len(query)-trailingBytes:])
+		if rest != "" && len(args) > 0 {
+			stmt.Finalize()
+			return nil, fmt.Errorf("sqlitedriver: query %q with args has trailing bytes", query)
+		}
+		res, err := exec(c.conn, stmt, args)
+		if ferr := stmt.Finalize(); err == nil {
+			err = ferr
+		}
+		if err != nil {
+			return nil, ctxErr(ctx, err)
+		}
+		if rest == "" {
+			return res, nil
+		}
+		query = rest
+	}
+}
+
+// QueryContext executes a query that may return rows.
+func (c *Conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	s, err := c.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.(*stmtWrapper).QueryContext(ctx, args)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	r.(*rows).closeStmt = true
+	return r, nil
+}
+
+// CheckNamedValue lets values implementing sqlite.Binder through to
+// be bound by BindSQLite, and converts all others as database/sql
+// does by default.
+func (c *Conn) CheckNamedValue(nv *driver.NamedValue) (err error) {
+	if _, ok := nv.Value.(sqlite.Binder); ok {
+		return nil
+	}
+	nv.Value, err = driver.DefaultParameterConverter.ConvertValue(nv.Value)
+	return err
+}
+
+// Begin begins a transaction.
+func (c *Conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx begins a transaction.
+//
+// The transaction is started with BEGIN, or is a SAVEPOINT if the
+// connection is already in a transaction, such as one started by
+// executing BEGIN directly. SQLite transactions are serializable, which satisfies every
+// isolation level; LevelReadUncommitted additionally sets the
+// read_uncommitted pragma, which only has an effect in shared cache
+// mode. A read-only transaction sets the query_only pragma.
+func (c *Conn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if c.tx != nil {
+		return nil, errors.New("sqlitedriver: transaction already in progress")
+	}
+	t := &tx{conn: c}
+	switch sql.IsolationLevel(opts.Isolation) {
+	case sql.LevelDefault, sql.LevelReadCommitted, sql.LevelWriteCommitted,
+		sql.LevelRepeatableRead, sql.LevelSnapshot, sql.LevelSerializable,
+		sql.LevelLinearizable:
+	case sql.LevelReadUncommitted:
+		t.pragmas = append(t.pragmas, "read_uncommitted")
+	default:
+		return nil, fmt.Errorf("sqlitedriver: unsupported isolation level %v", sql.IsolationLevel(opts.Isolation))
+	}
+	if opts.ReadOnly {
+		t.pragmas = append(t.pragmas, "query_only")
+	}
+	for i, pragma := range t.pragmas {
+		if err := sqlitex.ExecTransient(c.conn, "PRAGMA "+pragma+" = 1;", nil); err != nil {
+			t.pragmas = t.pragmas[:i]
+			t.resetPragmas()
+			return nil, err
+		}
+	}
+	begin := "BEGIN;"
+	if !c.conn.GetAutocommit() {
+		t.savepoint = true
+		begin = "SAVEPOINT " + txSavepoint + ";"
+	}
+	if err := sqlitex.ExecTransient(c.conn, begin, nil); err != nil {
+		t.resetPragmas()
+		return nil, err
+	}
+	c.tx = t
+	return t, nil
+}
+
+// txSavepoint names the SAVEPOINT of a transaction begun inside
+// another.
+const txSavepoint = `"sqlitedriver.tx"`
+
+type tx struct {
+	conn      *Conn
+	savepoint bool     // the transaction is a SAVEPOINT
+	pragmas   []string // set to 1 for the transaction
+}
+
+func (t *tx) Commit() error {
+	if t.conn.tx != t {
+		return sql.ErrTxDone
+	}
+	commit := "COMMIT;"
+	if t.savepoint {
+		commit = "RELEASE " + txSavepoint + ";"
+	}
+	err := sqlitex.ExecTransient(t.conn.conn, commit, nil)
+	if err != nil && !t.conn.conn.GetAutocommit() {
+		// The transaction is still open, as when COMMIT fails with
+		// SQLITE_BUSY. It is over for database/sql, so roll it back.
+		if rerr := t.rollback(); rerr != nil {
+			err = fmt.Errorf("%v; rollback: %v", err, rerr)
+		}
+	}
+	return t.end(err)
+}
+
+func (t *tx) Rollback() error {
+	if t.conn.tx != t {
+		return sql.ErrTxDone
+	}
+	return t.end(t.rollback())
+}
+
+// rollback rolls back the transaction, even if the Conn has been
+// interrupted.
+func (t *tx) rollback() error {
+	conn := t.conn.conn
+	if conn.GetAutocommit() {
+		// An error or a user's COMMIT or ROLLBACK has already
+		// ended the transaction.
+		return nil
+	}
+	oldDoneCh := conn.SetInterrupt(nil)
+	defer conn.SetInterrupt(oldDoneCh)
+	if !t.savepoint {
+		return sqlitex.ExecTransient(conn, "ROLLBACK;", nil)
+	}
+	if err := sqlitex.ExecTransient(conn, "ROLLBACK TO "+txSavepoint+";", nil); err != nil {
+		return err
+	}
+	return sqlitex.ExecTransient(conn, "RELEASE "+txSavepoint+";", nil)
+}
+
+func (t *tx) end(err error) error {
+	t.conn.tx = nil
+	if perr := t.resetPragmas(); err == nil {
+		err = perr
+	}
+	return err
+}
+
+func (t *tx) resetPragmas() (err error) {
+	for _, pragma := range t.pragmas {
+		if perr := sqlitex.ExecTransient(t.conn.conn, "PRAGMA "+pragma+" = 0;", nil); err == nil {
+			err = perr
+		}
+	}
+	return err
+}
+
+// stmtWrapper is a prepared statement.
+type stmtWrapper struct {
+	conn *Conn
+	stmt *sqlite.Stmt
+}
+
+func (s *stmtWrapper) Close() error {
+	return s.stmt.Finalize()
+}
+
+func (s *stmtWrapper) NumInput() int {
+	return s.stmt.BindParamCount()
+}
+
+func (s *stmtWrapper) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *stmtWrapper) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *stmtWrapper) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	restore, err := s.conn.interrupt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer restore()
+	res, err := exec(s.conn.conn, s.stmt, args)
+	if rerr := s.stmt.Reset(); err == nil {
+		err = rerr
+	}
+	if err != nil {
+		return nil, ctxErr(ctx, err)
+	}
+	return res, nil
+}
+
+func (s *stmtWrapper) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	restore, err := s.conn.interrupt(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := bind(s.stmt, args); err != nil {
+		restore()
+		return nil, err
+	}
+	return &rows{ctx: ctx, stmt: s.stmt, restore: restore}, nil
+}
+
+func (s *stmtWrapper) CheckNamedValue(nv *driver.NamedValue) error {
+	return s.conn.CheckNamedValue(nv)
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	nvs := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		nvs[i] = driver.NamedValue{Ordinal: i + 1, Value: arg}
+	}
+	return nvs
+}
+
+// bind clears the bindings of stmt and binds args.
+func bind(stmt *sqlite.Stmt, args []driver.NamedValue) error {
+	if err := stmt.Reset(); err != nil {
+		return err
+	}
+	if err := stmt.ClearBindings(); err != nil {
+		return err
+	}
+	for _, arg := range args {
+		param := arg.Ordinal
+		if arg.Name != "" {
+			param = 0
+			for i := 1; i <= stmt.BindParamCount(); i++ {
+				name := stmt.BindParamName(i)
+				if name != "" && name[1:] == arg.Name {
+					param = i
+					break
+				}
+			}
+			if param == 0 {
+				return fmt.Errorf("sqlitedriver: no parameter named %q", arg.Name)
+			}
+		}
+		switch v := arg.Value.(type) {
+		case nil:
+			stmt.BindNull(param)
+		case int64:
+			stmt.BindInt64(param, v)
+		case float64:
+			stmt.BindFloat(param, v)
+		case bool:
+			stmt.BindBool(param, v)
+		case []byte:
+			stmt.BindBytes(param, v)
+		case string:
+			stmt.BindText(param, v)
+		case time.Time:
+			stmt.BindTime(param, v)
+		case sqlite.Binder:
+			if err := v.BindSQLite(stmt, param); err != nil {
+				return fmt.Errorf("sqlitedriver: parameter %d: %T.BindSQLite: %v", param, v, err)
+			}
+		default:
+			return fmt.Errorf("sqlitedriver: parameter %d: unsupported type %T", param, v)
+		}
+	}
+	return nil
+}
+
+func exec(conn *sqlite.Conn, stmt *sqlite.Stmt, args []driver.NamedValue) (driver.Result, error) {
+	if err := bind(stmt, args); err != nil {
+		return nil, err
+	}
+	for {
+		hasRow, err := stmt.Step()
+		if err != nil {
+			return nil, err
+		}
+		if !hasRow {
+			break
+		}
+	}
+	return result{
+		lastInsertID: conn.LastInsertRowID(),
+		rowsAffected: int64(conn.Changes()),
+	}, nil
+}
+
+type result struct {
+	lastInsertID int64
+	rowsAffected int64
+}
+
+func (r result) LastInsertId() (int64, error) { return r.lastInsertID, nil }
+func (r result) RowsAffected() (int64, error) { return r.rowsAffected, nil }
+
+// rows is the result of a query.
+type rows struct {
+	ctx       context.Context
+	stmt      *sqlite.Stmt
+	restore   func()
+	closeStmt bool   // finalize stmt on Close
+	closed    bool
+	timeCols  []bool // columns declared DATE, DATETIME or TIMESTAMP
+}
+
+func (r *rows) Columns() []string {
+	cols := make([]string, r.stmt.ColumnCount())
+	for i := range cols {
+		cols[i] = r.stmt.ColumnName(i)
+	}
+	return cols
+}
+
+func (r *rows) Close() (err error) {
+	if r.closed {
+		return nil
+	}
+	r.closed = true
+	err = r.stmt.Reset()
+	r.restore()
+	if r.closeStmt {
+		if ferr := r.stmt.Finalize(); err == nil {
+			err = ferr
+		}
+	}
+	return ctxErr(r.ctx, err)
+}
+
+func (r *rows) Next(dest []driver.Value) error {
+	hasRow, err := r.stmt.Step()
+	if err != nil {
+		return ctxErr(r.ctx, err)
+	}
+	if !hasRow {
+		return io.EOF
+	}
+	if r.timeCols == nil {
+		r.timeCols = make([]bool, len(dest))
+		for i := range dest {
+			switch strings.ToUpper(r.stmt.ColumnDeclType(i)) {
+			case "DATE", "DATETIME", "TIMESTAMP":
+				r.timeCols[i] = true
+			}
+		}
+	}
+	for i := range dest {
+		typ := r.stmt.ColumnType(i)
+		if r.timeCols[i] && (typ == sqlite.SQLITE_TEXT || typ == sqlite.SQLITE_INTEGER) {
+			// Return times as bound with BindTime, so they Scan
+			// into a time.Time. Text that is not a time is a string.
+			if t, err := r.stmt.ColumnTime(i); err == nil {
+				dest[i] = t
+				continue
+			}
+		}
+		switch typ {
+		case sqlite.SQLITE_INTEGER:
+			dest[i] = r.stmt.ColumnInt64(i)
+		case sqlite.SQLITE_FLOAT:
+			dest[i] = r.stmt.ColumnFloat(i)
+		case sqlite.SQLITE_TEXT:
+			dest[i] = r.stmt.ColumnText(i)
+		case sqlite.SQLITE_BLOB:
+			b := make([]byte, r.stmt.ColumnLen(i))
+			r.stmt.ColumnBytes(i, b)
+			dest[i] = b
+		default:
+			dest[i] = nil
+		}
+	}
+	return nil
+}
+
+var (
+	_ driver.DriverContext      = (*Driver)(nil)
+	_ driver.ConnBeginTx        = (*Conn)(nil)
+	_ driver.ConnPrepareContext = (*Conn)(nil)
+	_ driver.ExecerContext      = (*Conn)(nil)
+	_ driver.QueryerContext     = (*Conn)(nil)
+	_ driver.NamedValueChecker  = (*Conn)(nil)
+	_ driver.SessionResetter    = (*Conn)(nil)
+	_ driver.StmtExecContext    = (*stmtWrapper)(nil)
+	_ driver.StmtQueryContext   = (*stmtWrapper)(nil)
+)
@@ -0,0 +1,327 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitedriver_test
+
+import (
+	"context"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitedriver"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+func openDB(t *testing.T) (db *sql.DB, cleanup func()) {
+	dir, err := ioutil.TempDir("", "sqlitedriver-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db, err = sql.Open(sqlitedriver.DriverName, "file:"+filepath.Join(dir, "test.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestDriver(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, `CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, data BLOB, score REAL);
+		-- several statements without args
+		CREATE INDEX t_name ON t (name); -- trailing comment`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := db.ExecContext(ctx, "INSERT INTO t (name, data, score) VALUES (?, ?, ?);", "ann", []byte{1}, 1.5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := res.LastInsertId(); id != 1 {
+		t.Errorf("LastInsertId = %d, want 1", id)
+	}
+	_, err = db.ExecContext(ctx, "INSERT INTO t (name, score) VALUES ($name, :score);",
+		sql.Named("score", 2), sql.Named("name", "bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stmt, err := db.PrepareContext(ctx, "SELECT id, name, data, score FROM t WHERE score >= ? ORDER BY id;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Close()
+	for _, want := range []int{2, 1} {
+		rows, err := stmt.QueryContext(ctx, 3-want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for rows.Next() {
+			var id int64
+			var name string
+			var data []byte
+			var score float64
+			if err := rows.Scan(&id, &name, &data, &score); err != nil {
+				t.Fatal(err)
+			}
+			n++
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		rows.Close()
+		if n != want {
+			t.Errorf("got %d rows, want %d", n, want)
+		}
+	}
+
+	var name string
+	var data []byte
+	if err := db.QueryRowContext(ctx, "SELECT name, data FROM t WHERE id = @id;", sql.Named("id", 2)).Scan(&name, &data); err != nil {
+		t.Fatal(err)
+	}
+	if name != "bob" || data != nil {
+		t.Errorf("name = %q, data = %v", name, data)
+	}
+	if _, err := db.ExecContext(ctx, "SELECT 1;", sql.Named("missing", 1)); err == nil {
+		t.Error("unknown named arg succeeded")
+	}
+}
+
+func TestDriverTx(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE t (c);"); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO t VALUES (1);"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err = db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO t VALUES (2);"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != sql.ErrTxDone {
+		t.Errorf("second Commit err = %v, want sql.ErrTxDone", err)
+	}
+
+	var sum int
+	if err := db.QueryRowContext(ctx, "SELECT sum(c) FROM t;").Scan(&sum); err != nil {
+		t.Fatal(err)
+	}
+	if sum != 2 {
+		t.Errorf("sum = %d, want 2", sum)
+	}
+
+	tx, err = db.BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO t VALUES (3);"); err == nil {
+		t.Error("INSERT in read-only transaction succeeded")
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO t VALUES (3);"); err != nil {
+		t.Errorf("INSERT after read-only transaction: %v", err)
+	}
+}
+
+func TestDriverTxErrors(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE t (c);"); err != nil {
+		t.Fatal(err)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	raw := func(fn func(c *sqlite.Conn) error) {
+		t.Helper()
+		err := conn.Raw(func(driverConn interface{}) error {
+			return fn(driverConn.(*sqlitedriver.Conn).SQLiteConn())
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A transaction inside one begun directly is a SAVEPOINT.
+	raw(func(c *sqlite.Conn) error { return sqlitex.ExecTransient(c, "BEGIN;", nil) })
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO t VALUES (1);"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Rollback(); err != nil {
+		t.Fatal(err)
+	}
+	raw(func(c *sqlite.Conn) error {
+		if c.GetAutocommit() {
+			t.Error("Rollback of a nested transaction ended the outer one")
+		}
+		return sqlitex.ExecTransient(c, "ROLLBACK;", nil)
+	})
+
+	// Failing to begin is an error, not a panic.
+	raw(func(c *sqlite.Conn) error {
+		return c.SetAuthorizer(sqlite.AuthorizeFunc(func(info sqlite.ActionInfo) sqlite.AuthResult {
+			if info.Action == sqlite.SQLITE_TRANSACTION || info.Action == sqlite.SQLITE_SAVEPOINT {
+				return sqlite.SQLITE_DENY
+			}
+			return 0
+		}))
+	})
+	if _, err := conn.BeginTx(ctx, nil); sqlite.ErrCode(err) != sqlite.SQLITE_AUTH {
+		t.Errorf("BeginTx err = %v, want SQLITE_AUTH", err)
+	}
+	raw(func(c *sqlite.Conn) error { return c.SetAuthorizer(nil) })
+	tx, err = conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDriverTime(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE TABLE t (at TIMESTAMP, day date, note TEXT);"); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	if _, err := db.ExecContext(ctx, "INSERT INTO t VALUES (?, 1700000000, ?);", now, now); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.ExecContext(ctx, "INSERT INTO t VALUES ('soon', NULL, NULL);"); err != nil {
+		t.Fatal(err)
+	}
+
+	var at, day time.Time
+	var note string
+	if err := db.QueryRowContext(ctx, "SELECT at, day, note FROM t WHERE rowid = 1;").Scan(&at, &day, &note); err != nil {
+		t.Fatal(err)
+	}
+	if !at.Equal(now) {
+		t.Errorf("at = %v, want %v", at, now)
+	}
+	if want := time.Unix(1700000000, 0); !day.Equal(want) {
+		t.Errorf("day = %v, want %v", day, want)
+	}
+	if _, err := time.Parse(time.RFC3339Nano, note); err != nil {
+		t.Errorf("TEXT column note = %q, want the time as text: %v", note, err)
+	}
+
+	// Text that is not a time is returned as a string.
+	var soon string
+	if err := db.QueryRowContext(ctx, "SELECT at FROM t WHERE rowid = 2;").Scan(&soon); err != nil || soon != "soon" {
+		t.Errorf("at = %q, %v, want soon", soon, err)
+	}
+}
+
+func TestDriverCancel(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	const query = `WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x+1 FROM c) SELECT count(*) FROM c;`
+	var n int
+	err := db.QueryRowContext(ctx, query).Scan(&n)
+	if err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want context.DeadlineExceeded", err)
+	}
+	if err := db.QueryRowContext(context.Background(), "SELECT 1;").Scan(&n); err != nil || n != 1 {
+		t.Errorf("query after cancel: n=%d, err=%v", n, err)
+	}
+}
+
+func TestDriverRaw(t *testing.T) {
+	db, cleanup := openDB(t)
+	defer cleanup()
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = conn.Raw(func(driverConn interface{}) error {
+		c := driverConn.(*sqlitedriver.Conn).SQLiteConn()
+		stmt := c.Prep("SELECT 42;")
+		defer stmt.Reset()
+		if _, err := stmt.Step(); err != nil {
+			return err
+		}
+		if got := stmt.ColumnInt(0); got != 42 {
+			t.Errorf("got %d, want 42", got)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var v int64
+	if err := conn.QueryRowContext(ctx, "SELECT ?;", binder(7)).Scan(&v); err != nil {
+		t.Fatal(err)
+	}
+	if v != 70 {
+		t.Errorf("Binder bound %d, want 70", v)
+	}
+}
+
+type binder int64
+
+func (b binder) BindSQLite(stmt *sqlite.Stmt, param int) error {
+	stmt.BindInt64(param, int64(b)*10)
+	return nil
+}
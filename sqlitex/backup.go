@@ -0,0 +1,123 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"context"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+// BackupOptions configures Backup.
+type BackupOptions struct {
+	// DstDB is the name of the destination database on the dst
+	// connection. If it is "", then "main" is used.
+	DstDB string
+
+	// PagesPerStep is the number of pages copied while the source
+	// database is locked. A value of 0 defaults to 100.
+	PagesPerStep int
+
+	// Sleep is how long Backup waits between steps, so that writers
+	// can make progress. It is also how long Backup waits before
+	// retrying a step that failed with SQLITE_BUSY or SQLITE_LOCKED.
+	// A value of 0 defaults to 10ms. A negative value does not wait
+	// between steps.
+	Sleep time.Duration
+
+	// Progress, if non-nil, is called after each successful step with
+	// the number of pages still to be copied and the total number of
+	// pages in the source database, as reported by Backup.Remaining
+	// and Backup.PageCount.
+	Progress func(remaining, pageCount int)
+}
+
+// Backup copies the srcDB database on src to dst, a few pages at a
+// time, so that other connections can keep writing to the source
+// while a large database is backed up.
+//
+// If srcDB is "", then "main" is used.
+//
+// Each step holds a read lock on the source for opts.PagesPerStep
+// pages. A step that fails because the source or destination is busy
+// or locked is retried. If the source is written to by another
+// connection during the backup, SQLite restarts the backup from the
+// beginning, so a database that is written to faster than it can be
+// copied may never finish. (Writes through src itself are copied into
+// the backup as they happen, without a restart.)
+//
+// If ctx is done before the backup completes, Backup stops between
+// steps and returns ctx.Err(). The destination is then left with a
+// partial copy and should be discarded.
+//
+// https://www.sqlite.org/backup.html
+func Backup(ctx context.Context, src *sqlite.Conn, srcDB string, dst *sqlite.Conn, opts BackupOptions) (err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	nPage := opts.PagesPerStep
+	if nPage <= 0 {
+		nPage = 100
+	}
+	sleep := opts.Sleep
+	if sleep == 0 {
+		sleep = 10 * time.Millisecond
+	}
+
+	b, err := src.BackupInit(srcDB, opts.DstDB, dst)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if ferr := b.Finish(); err == nil {
+			err = ferr
+		}
+	}()
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		// Step returns nil for both SQLITE_OK and SQLITE_DONE. SQLite
+		// reports SQLITE_DONE exactly when no pages remain.
+		err := b.Step(nPage)
+		if err != nil && !retryable(err) {
+			return err
+		}
+		if err == nil {
+			if opts.Progress != nil {
+				opts.Progress(b.Remaining(), b.PageCount())
+			}
+			if b.Remaining() == 0 {
+				return nil
+			}
+		}
+		if sleep < 0 {
+			if err != nil {
+				// Do not spin on a locked database.
+				time.Sleep(retryMinDelay)
+			}
+			continue
+		}
+		t := time.NewTimer(sleep)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
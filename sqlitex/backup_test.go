@@ -0,0 +1,183 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+func openBackupSrc(t *testing.T, dir string) *sqlite.Conn {
+	src, err := sqlite.OpenConn(filepath.Join(dir, "src.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sqlitex.ExecScript(src, `
+		CREATE TABLE t (c);
+		WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i+1 FROM n WHERE i < 200)
+		INSERT INTO t (c) SELECT randomblob(100) FROM n;`)
+	if err != nil {
+		src.Close()
+		t.Fatal(err)
+	}
+	return src
+}
+
+func TestBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlitex-backup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := openBackupSrc(t, dir)
+	defer src.Close()
+
+	// Hold the write lock on the destination, so that the first step
+	// fails with SQLITE_BUSY and is retried.
+	dstPath := filepath.Join(dir, "dst.db")
+	locker, err := sqlite.OpenConn(dstPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer locker.Close()
+	if err := sqlitex.Exec(locker, "CREATE TABLE x (c);", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlitex.Exec(locker, "BEGIN IMMEDIATE;", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := sqlite.OpenConn(dstPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	dst.SetBusyTimeout(0)
+
+	var remaining []int
+	var pageCount int
+	retried := false
+	// Backup checks the context before each step. The second check
+	// follows the first step, which is a retry if it made no progress.
+	// Release the lock there, on the Backup goroutine.
+	ctx := &checkCtx{Context: context.Background(), check: func(n int) {
+		if n == 2 && len(remaining) == 0 {
+			retried = true
+			if err := sqlitex.Exec(locker, "ROLLBACK;", nil); err != nil {
+				t.Error(err)
+			}
+		}
+	}}
+	err = sqlitex.Backup(ctx, src, "", dst, sqlitex.BackupOptions{
+		PagesPerStep: 2,
+		Sleep:        time.Millisecond,
+		Progress: func(r, n int) {
+			remaining = append(remaining, r)
+			pageCount = n
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !retried {
+		t.Error("first step was not retried")
+	}
+	if len(remaining) < 2 || remaining[len(remaining)-1] != 0 {
+		t.Errorf("progress remaining = %v, want several steps ending in 0", remaining)
+	}
+	if want := (pageCount + 1) / 2; len(remaining) != want {
+		t.Errorf("%d steps for %d pages, want %d", len(remaining), pageCount, want)
+	}
+	if n, err := sqlitex.ResultInt(dst.Prep("SELECT count(*) FROM t;")); err != nil || n != 200 {
+		t.Errorf("dst count = %d, %v, want 200", n, err)
+	}
+}
+
+// checkCtx is a Context that calls check with the number of calls
+// to Err so far.
+type checkCtx struct {
+	context.Context
+	n     int
+	check func(n int)
+}
+
+func (ctx *checkCtx) Err() error {
+	ctx.n++
+	ctx.check(ctx.n)
+	return ctx.Context.Err()
+}
+
+func TestBackupCancel(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlitex-backup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := openBackupSrc(t, dir)
+	defer src.Close()
+	dst, err := sqlite.OpenConn(filepath.Join(dir, "dst.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	steps := 0
+	err = sqlitex.Backup(ctx, src, "main", dst, sqlitex.BackupOptions{
+		PagesPerStep: 1,
+		Progress: func(r, n int) {
+			if steps++; steps == 2 {
+				cancel()
+			}
+		},
+	})
+	if err != context.Canceled {
+		t.Fatalf("Backup err = %v, want context.Canceled", err)
+	}
+	if steps != 2 {
+		t.Errorf("%d steps after cancel, want 2", steps)
+	}
+
+	// The partial backup is discarded and dst is usable.
+	if err := sqlitex.Exec(dst, "CREATE TABLE y (c);", nil); err != nil {
+		t.Errorf("dst after cancel: %v", err)
+	}
+}
+
+func TestBackupNoSuchDB(t *testing.T) {
+	src, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	dst, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	err = sqlitex.Backup(nil, src, "nosuchdb", dst, sqlitex.BackupOptions{})
+	if err == nil || !strings.Contains(err.Error(), "nosuchdb") {
+		t.Errorf("Backup err = %v, want unknown database", err)
+	}
+}
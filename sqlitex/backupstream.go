@@ -0,0 +1,283 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"crawshaw.io/sqlite"
+)
+
+// Stream backups written by WriteBackup have the format:
+//
+//	magic      [8]byte  "SQLXBKUP"
+//	version    byte     1
+//	flags      byte     bit 0 set if the pages are gzip compressed
+//	reserved   [2]byte
+//	page size  uint32   big-endian
+//	page count uint32   big-endian
+//	pages      page count × page size bytes, possibly in one gzip member
+//	checksum   [32]byte SHA-256 of the uncompressed pages
+const (
+	backupMagic      = "SQLXBKUP"
+	backupVersion    = 1
+	backupGzip       = 1 << 0
+	backupHeaderSize = 20
+)
+
+// WriteBackupOptions configures WriteBackup.
+type WriteBackupOptions struct {
+	// Gzip compresses the database pages.
+	Gzip bool
+}
+
+// WriteBackup writes a consistent image of the schema database on
+// conn to w, in a stream that RestoreBackup reads. If schema is "",
+// then "main" is used.
+//
+// The image is taken with Conn.Serialize in a single read
+// transaction, so it is consistent, and in WAL mode writers can
+// continue while it is taken. If conn is already in a transaction,
+// WriteBackup reads in it, so a backup can be taken from a Snapshot
+// after Conn.StartSnapshotRead. No temporary file is used, but the
+// image is held in memory while it is written to w.
+//
+// If ctx is done before the backup is written, WriteBackup returns
+// ctx.Err(). The stream written to w is then incomplete.
+func WriteBackup(ctx context.Context, conn *sqlite.Conn, schema string, w io.Writer, opts WriteBackupOptions) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if schema == "" {
+		schema = "main"
+	}
+	if conn.GetAutocommit() {
+		if err := Exec(conn, "BEGIN;", nil); err != nil {
+			return fmt.Errorf("sqlitex.WriteBackup: %v", err)
+		}
+		defer Exec(conn, "ROLLBACK;", nil)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// The pragmas start the read transaction, fixing the page count.
+	var pageSize, pageCount int
+	for _, p := range []struct {
+		name string
+		val  *int
+	}{{"page_size", &pageSize}, {"page_count", &pageCount}} {
+		stmt, err := conn.Prepare("PRAGMA " + quoteIdent(schema) + "." + p.name + ";")
+		if err == nil {
+			*p.val, err = ResultInt(stmt)
+		}
+		if err != nil {
+			return fmt.Errorf("sqlitex.WriteBackup: %v", err)
+		}
+	}
+
+	var hdr [backupHeaderSize]byte
+	copy(hdr[:], backupMagic)
+	hdr[8] = backupVersion
+	if opts.Gzip {
+		hdr[9] |= backupGzip
+	}
+	binary.BigEndian.PutUint32(hdr[12:], uint32(pageSize))
+	binary.BigEndian.PutUint32(hdr[16:], uint32(pageCount))
+
+	bw := bufio.NewWriter(w)
+	if _, err := bw.Write(hdr[:]); err != nil {
+		return err
+	}
+	var out io.Writer = bw
+	var zw *gzip.Writer
+	if opts.Gzip {
+		zw = gzip.NewWriter(bw)
+		out = zw
+	}
+	h := sha256.New()
+	out = io.MultiWriter(out, h)
+
+	n, err := conn.Serialize(schema, ctxWriter{ctx, out})
+	if err != nil {
+		return err
+	}
+	if want := int64(pageSize) * int64(pageCount); n != want {
+		return fmt.Errorf("sqlitex.WriteBackup: wrote %d bytes, want %d", n, want)
+	}
+
+	if zw != nil {
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	}
+	if _, err := bw.Write(h.Sum(nil)); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// RestoreBackup replaces the schema database on conn with a backup
+// written by WriteBackup and read from r. If schema is "", then "main"
+// is used.
+//
+// The image is written to a temporary file and its checksum is
+// verified before anything is written to conn, so a truncated or
+// corrupt stream leaves the database untouched. The image is then
+// copied in with Backup. The page size of a WAL mode database cannot
+// be changed, so restoring into a WAL mode database with a different
+// page size fails.
+//
+// Reading r stops when ctx is done, and RestoreBackup returns
+// ctx.Err().
+func RestoreBackup(ctx context.Context, conn *sqlite.Conn, schema string, r io.Reader) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	br := bufio.NewReader(ctxReader{ctx, r})
+
+	var hdr [backupHeaderSize]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return fmt.Errorf("sqlitex.RestoreBackup: reading header: %v", err)
+	}
+	if !bytes.Equal(hdr[:8], []byte(backupMagic)) {
+		return strerror{msg: "sqlitex.RestoreBackup: not a backup stream"}
+	}
+	if hdr[8] != backupVersion {
+		return fmt.Errorf("sqlitex.RestoreBackup: unsupported version %d", hdr[8])
+	}
+	if hdr[9]&^backupGzip != 0 {
+		return fmt.Errorf("sqlitex.RestoreBackup: unknown flags %#x", hdr[9])
+	}
+	pageSize := binary.BigEndian.Uint32(hdr[12:])
+	pageCount := binary.BigEndian.Uint32(hdr[16:])
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return fmt.Errorf("sqlitex.RestoreBackup: invalid page size %d", pageSize)
+	}
+
+	var in io.Reader = br
+	var zr *gzip.Reader
+	if hdr[9]&backupGzip != 0 {
+		var err error
+		if zr, err = gzip.NewReader(br); err != nil {
+			return fmt.Errorf("sqlitex.RestoreBackup: %v", err)
+		}
+		// br is an io.ByteReader, so zr reads no further than the
+		// end of its member, leaving the checksum in br.
+		zr.Multistream(false)
+		in = zr
+	}
+	h := sha256.New()
+
+	f, err := ioutil.TempFile("", "sqlitex-restore-*.db")
+	if err != nil {
+		return fmt.Errorf("sqlitex.RestoreBackup: %v", err)
+	}
+	defer os.Remove(f.Name())
+	size := int64(pageSize) * int64(pageCount)
+	_, err = io.CopyN(f, &journalModeReader{r: io.TeeReader(in, h)}, size)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("sqlitex.RestoreBackup: reading pages: %v", err)
+	}
+	if zr != nil {
+		// Reading to EOF checks the gzip trailer.
+		if _, err := zr.Read(make([]byte, 1)); err != io.EOF {
+			if err == nil {
+				err = strerror{msg: "trailing data"}
+			}
+			return fmt.Errorf("sqlitex.RestoreBackup: %v", err)
+		}
+	}
+	var sum [sha256.Size]byte
+	if _, err := io.ReadFull(br, sum[:]); err != nil {
+		return fmt.Errorf("sqlitex.RestoreBackup: reading checksum: %v", err)
+	}
+	if !bytes.Equal(sum[:], h.Sum(nil)) {
+		return strerror{msg: "sqlitex.RestoreBackup: checksum mismatch"}
+	}
+
+	src, err := sqlite.OpenConn(f.Name(), sqlite.SQLITE_OPEN_READONLY|sqlite.SQLITE_OPEN_NOMUTEX)
+	if err != nil {
+		return fmt.Errorf("sqlitex.RestoreBackup: %v", err)
+	}
+	defer src.Close()
+	return Backup(ctx, src, "", conn, BackupOptions{DstDB: schema, Sleep: -1})
+}
+
+// journalModeReader reads a database image, changing a WAL mode
+// database to a rollback journal database, so that opening the
+// restored image does not create a WAL beside it. The backup API sets
+// the journal mode of the destination as it copies the image.
+type journalModeReader struct {
+	r   io.Reader
+	off int64
+}
+
+func (r *journalModeReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	// Bytes 18 and 19 of the header are the file format write and
+	// read versions, 2 for WAL mode and 1 for a rollback journal.
+	for i := int64(18); i <= 19; i++ {
+		if j := i - r.off; j >= 0 && j < int64(n) && p[j] == 2 {
+			p[j] = 1
+		}
+	}
+	r.off += int64(n)
+	return n, err
+}
+
+// ctxWriter is an io.Writer that fails once ctx is done.
+type ctxWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (w ctxWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return w.w.Write(p)
+}
+
+// ctxReader is an io.Reader that fails once ctx is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r ctxReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
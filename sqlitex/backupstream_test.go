@@ -0,0 +1,139 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+func TestWriteBackup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlitex-backup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := openBackupSrc(t, dir)
+	defer src.Close()
+
+	for _, gz := range []bool{false, true} {
+		var buf bytes.Buffer
+		if err := sqlitex.WriteBackup(context.Background(), src, "", &buf, sqlitex.WriteBackupOptions{Gzip: gz}); err != nil {
+			t.Fatalf("gzip=%v: %v", gz, err)
+		}
+		if !src.GetAutocommit() {
+			t.Fatal("WriteBackup left a transaction open")
+		}
+		data := buf.Bytes()
+
+		dst, err := sqlite.OpenConn(filepath.Join(dir, "dst.db"), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := sqlitex.RestoreBackup(context.Background(), dst, "", bytes.NewReader(data)); err != nil {
+			t.Fatalf("gzip=%v: %v", gz, err)
+		}
+		if n, err := sqlitex.ResultInt(dst.Prep("SELECT count(*) FROM t;")); err != nil || n != 200 {
+			t.Errorf("gzip=%v: dst count = %d, %v, want 200", gz, n, err)
+		}
+		if err := sqlitex.Exec(dst, "DELETE FROM t WHERE rowid > 100;", nil); err != nil {
+			t.Fatal(err)
+		}
+
+		// A damaged stream leaves the database untouched.
+		damaged := map[string][]byte{
+			"truncated": data[:len(data)-1],
+			"corrupt":   append(append([]byte{}, data[:len(data)-1]...), data[len(data)-1]^1),
+			"header":    append([]byte("X"), data[1:]...),
+			"huge":      append(append(append([]byte{}, data[:16]...), 0xff, 0xff, 0xff, 0xff), data[20:]...),
+		}
+		for name, d := range damaged {
+			if err := sqlitex.RestoreBackup(nil, dst, "main", bytes.NewReader(d)); err == nil {
+				t.Errorf("gzip=%v: %s stream restored", gz, name)
+			}
+			if n, err := sqlitex.ResultInt(dst.Prep("SELECT count(*) FROM t;")); err != nil || n != 100 {
+				t.Errorf("gzip=%v: after %s stream dst count = %d, %v, want 100", gz, name, n, err)
+			}
+		}
+		dst.Close()
+		os.Remove(filepath.Join(dir, "dst.db"))
+	}
+}
+
+func TestWriteBackupSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlitex-backup-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := openBackupSrc(t, dir)
+	defer src.Close()
+	writer, err := sqlite.OpenConn(filepath.Join(dir, "src.db"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	// Writes after the read transaction starts are not in the backup.
+	if err := sqlitex.Exec(src, "BEGIN;", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sqlitex.ResultInt(src.Prep("SELECT count(*) FROM t;")); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlitex.Exec(writer, "DELETE FROM t;", nil); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := sqlitex.WriteBackup(context.Background(), src, "main", &buf, sqlitex.WriteBackupOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if src.GetAutocommit() {
+		t.Fatal("WriteBackup ended the caller's transaction")
+	}
+	if err := sqlitex.Exec(src, "ROLLBACK;", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if err := sqlitex.RestoreBackup(context.Background(), dst, "", &buf); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sqlitex.ResultInt(dst.Prep("SELECT count(*) FROM t;")); err != nil || n != 200 {
+		t.Errorf("dst count = %d, %v, want 200", n, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = sqlitex.WriteBackup(ctx, src, "", &buf, sqlitex.WriteBackupOptions{})
+	if err != context.Canceled {
+		t.Errorf("WriteBackup with done ctx err = %v, want context.Canceled", err)
+	}
+	if err := sqlitex.WriteBackup(nil, src, "nosuchdb", &buf, sqlitex.WriteBackupOptions{}); err == nil || !strings.Contains(err.Error(), "nosuchdb") {
+		t.Errorf("WriteBackup of unknown schema err = %v", err)
+	}
+}
@@ -16,9 +16,13 @@
 package sqlitex // import "crawshaw.io/sqlite/sqlitex"
 
 import (
+	"database/sql/driver"
+	"encoding"
 	"fmt"
 	"reflect"
+	"sort"
 	"strings"
+	"time"
 
 	"crawshaw.io/sqlite"
 )
@@ -31,16 +35,28 @@ import (
 // the error value.
 //
 // Any args provided to Exec are bound to numbered parameters of the
-// query using the Stmt Bind* methods. Basic reflection on args is used
-// to map:
+// query using the Stmt Bind* methods. An arg that implements one of
+// these interfaces is bound by the first that applies:
 //
-//	integers to BindInt64
-//	floats   to BindFloat
-//	[]byte   to BindBytes
-//	string   to BindText
-//	bool     to BindBool
+//	sqlite.Binder            BindSQLite
+//	driver.Valuer            the result of Value, bound by these rules
+//	encoding.TextMarshaler   the result of MarshalText, to BindText
+//	encoding.BinaryMarshaler the result of MarshalBinary, to BindBytes
 //
-// All other kinds are printed using fmt.Sprintf("%v", v) and passed
+// Otherwise basic reflection on args is used to map:
+//
+//	integers  to BindInt64
+//	floats    to BindFloat
+//	[]byte    to BindBytes
+//	string    to BindText
+//	bool      to BindBool
+//	time.Time to BindTime
+//
+// A time.Time, or a pointer to one, is bound with BindTime rather than
+// by its MarshalText method, so it honors the Conn's TimeFormat.
+// A nil pointer is bound as NULL, and any other pointer that does not
+// implement one of the interfaces above is bound as the value it points
+// to. All other kinds are printed using fmt.Sprintf("%v", v) and passed
 // to BindText.
 //
 // Exec is implemented using the Stmt prepare mechanism which allows
@@ -83,6 +99,127 @@ func Exec(conn *sqlite.Conn, query string, resultFn func(stmt *sqlite.Stmt) erro
 	return err
 }
 
+// ExecNamed executes an SQLite query, binding params to the named
+// parameters of the query.
+//
+// Params is either a map[string]interface{} or a struct (or pointer to
+// a struct) whose fields are mapped to names as by QueryStructs.
+// A map key may be written with or without the parameter prefix,
+// so a query parameter $id, :id, or @id is bound from the key "id"
+// or the full parameter name. Values are bound as by Exec, and a nil
+// pointer field binds NULL.
+//
+// It is an error for the query to have a parameter that is not in
+// params, or for params to have a value that the query does not use.
+// Nameless parameters (? and ?NNN) cannot be bound by ExecNamed.
+//
+// Like Exec, ExecNamed uses Conn.Prepare so the statement is cached.
+//
+//	err := sqlitex.ExecNamed(conn, "INSERT INTO t (a, b) VALUES ($a, $b);", nil, map[string]interface{}{
+//		"a": "a1",
+//		"b": 42,
+//	})
+func ExecNamed(conn *sqlite.Conn, query string, resultFn func(stmt *sqlite.Stmt) error, params interface{}) error {
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return annotateErr(err)
+	}
+	err = bindNamed(stmt, params)
+	if err == nil {
+		err = step(stmt, resultFn)
+	}
+	resetErr := stmt.Reset()
+	if err == nil {
+		err = resetErr
+	}
+	return err
+}
+
+func bindNamed(stmt *sqlite.Stmt, params interface{}) error {
+	// lookup returns the value for a parameter name, and
+	// the key under which the value was found.
+	var lookup func(name string) (key string, val interface{}, ok bool)
+	var keys []string
+
+	switch p := params.(type) {
+	case nil:
+		lookup = func(string) (string, interface{}, bool) { return "", nil, false }
+	case map[string]interface{}:
+		for key := range p {
+			keys = append(keys, key)
+		}
+		lookup = func(name string) (string, interface{}, bool) {
+			if val, ok := p[name]; ok {
+				return name, val, true
+			}
+			val, ok := p[name[1:]]
+			return name[1:], val, ok
+		}
+	default:
+		v := reflect.ValueOf(params)
+		if v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return fmt.Errorf("sqlitex.ExecNamed: params is %T, not a map[string]interface{} or struct", params)
+		}
+		plan, err := planFor(v.Type())
+		if err != nil {
+			return err
+		}
+		for _, f := range plan.fields {
+			keys = append(keys, f.name)
+		}
+		lookup = func(name string) (string, interface{}, bool) {
+			i, ok := plan.byName[strings.ToLower(name[1:])]
+			if !ok {
+				return "", nil, false
+			}
+			f := plan.fields[i]
+			return f.name, v.FieldByIndex(f.index).Interface(), true
+		}
+	}
+
+	used := make(map[string]bool)
+	var missing []string
+	for i := 1; i <= stmt.BindParamCount(); i++ {
+		name := stmt.BindParamName(i)
+		if name == "" || name[0] == '?' {
+			return fmt.Errorf("sqlitex.ExecNamed: parameter %d is not named", i)
+		}
+		key, val, ok := lookup(name)
+		if !ok {
+			missing = append(missing, name)
+			continue
+		}
+		used[key] = true
+		if err := bindArg(stmt, i, val); err != nil {
+			return err
+		}
+	}
+	var unused []string
+	for _, key := range keys {
+		if !used[key] {
+			unused = append(unused, key)
+		}
+	}
+	if len(missing) == 0 && len(unused) == 0 {
+		return nil
+	}
+	sort.Strings(unused)
+	msg := "sqlitex.ExecNamed:"
+	if len(missing) > 0 {
+		msg += " missing parameters " + strings.Join(missing, ", ")
+		if len(unused) > 0 {
+			msg += ";"
+		}
+	}
+	if len(unused) > 0 {
+		msg += " unused parameters " + strings.Join(unused, ", ")
+	}
+	return strerror{msg: msg}
+}
+
 // ExecTransient executes an SQLite query without caching the
 // underlying query.
 // The interface is exactly the same as Exec.
@@ -109,29 +246,88 @@ func ExecTransient(conn *sqlite.Conn, query string, resultFn func(stmt *sqlite.S
 
 func exec(stmt *sqlite.Stmt, resultFn func(stmt *sqlite.Stmt) error, args []interface{}) (err error) {
 	for i, arg := range args {
-		i++ // parameters are 1-indexed
-		v := reflect.ValueOf(arg)
-		switch v.Kind() {
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			stmt.BindInt64(i, v.Int())
-		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
-			stmt.BindInt64(i, int64(v.Uint()))
-		case reflect.Float32, reflect.Float64:
-			stmt.BindFloat(i, v.Float())
-		case reflect.String:
-			stmt.BindText(i, v.String())
-		case reflect.Bool:
-			stmt.BindBool(i, v.Bool())
-		case reflect.Invalid:
-			stmt.BindNull(i)
-		default:
-			if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
-				stmt.BindBytes(i, v.Bytes())
-			} else {
-				stmt.BindText(i, fmt.Sprintf("%v", arg))
-			}
+		if err := bindArg(stmt, i+1, arg); err != nil { // parameters are 1-indexed
+			return err
+		}
+	}
+	return step(stmt, resultFn)
+}
+
+// bindArg binds arg to parameter i of stmt using the
+// rules documented on Exec.
+func bindArg(stmt *sqlite.Stmt, i int, arg interface{}) error {
+	v := reflect.ValueOf(arg)
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		stmt.BindNull(i)
+		return nil
+	}
+	switch a := arg.(type) {
+	case sqlite.Binder:
+		if err := a.BindSQLite(stmt, i); err != nil {
+			return fmt.Errorf("sqlitex: parameter %d: %T.BindSQLite: %v", i, arg, err)
+		}
+		return nil
+	case driver.Valuer:
+		val, err := a.Value()
+		if err != nil {
+			return fmt.Errorf("sqlitex: parameter %d: %T.Value: %v", i, arg, err)
+		}
+		if _, isValuer := val.(driver.Valuer); isValuer {
+			return fmt.Errorf("sqlitex: parameter %d: %T.Value returned a driver.Valuer", i, arg)
+		}
+		return bindArg(stmt, i, val)
+	case time.Time:
+		stmt.BindTime(i, a)
+		return nil
+	case *time.Time:
+		stmt.BindTime(i, *a)
+		return nil
+	case encoding.TextMarshaler:
+		text, err := a.MarshalText()
+		if err != nil {
+			return fmt.Errorf("sqlitex: parameter %d: %T.MarshalText: %v", i, arg, err)
+		}
+		stmt.BindText(i, string(text))
+		return nil
+	case encoding.BinaryMarshaler:
+		data, err := a.MarshalBinary()
+		if err != nil {
+			return fmt.Errorf("sqlitex: parameter %d: %T.MarshalBinary: %v", i, arg, err)
+		}
+		if data == nil {
+			data = []byte{} // a marshaled value is never NULL
+		}
+		stmt.BindBytes(i, data)
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		stmt.BindInt64(i, v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		stmt.BindInt64(i, int64(v.Uint()))
+	case reflect.Float32, reflect.Float64:
+		stmt.BindFloat(i, v.Float())
+	case reflect.String:
+		stmt.BindText(i, v.String())
+	case reflect.Bool:
+		stmt.BindBool(i, v.Bool())
+	case reflect.Invalid:
+		stmt.BindNull(i)
+	case reflect.Ptr:
+		return bindArg(stmt, i, v.Elem().Interface())
+	default:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			stmt.BindBytes(i, v.Bytes())
+		} else {
+			stmt.BindText(i, fmt.Sprintf("%v", arg))
 		}
 	}
+	return nil
+}
+
+// step steps stmt to completion, calling resultFn for each row.
+func step(stmt *sqlite.Stmt, resultFn func(stmt *sqlite.Stmt) error) error {
 	for {
 		hasRow, err := stmt.Step()
 		if err != nil {
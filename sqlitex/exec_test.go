@@ -15,9 +15,15 @@
 package sqlitex_test
 
 import (
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"net"
 	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"crawshaw.io/sqlite"
 	"crawshaw.io/sqlite/sqlitex"
@@ -132,3 +138,219 @@ INSERT INTO t (a, b) VALUES ('a2', 2);
 		t.Errorf("sum=%d, want 3", sum)
 	}
 }
+
+func TestExecNamed(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := sqlitex.ExecScript(conn, "CREATE TABLE t (a TEXT, b INTEGER, c BLOB);"); err != nil {
+		t.Fatal(err)
+	}
+
+	const insert = "INSERT INTO t (a, b, c) VALUES ($a, :b, @c);"
+	err = sqlitex.ExecNamed(conn, insert, nil, map[string]interface{}{
+		"a":  "a1",
+		":b": 1,
+		"c":  nil,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type row struct {
+		A string  `sqlite:"a"`
+		B int     `sqlite:"b"`
+		C *[]byte `sqlite:"c"`
+	}
+	blob := []byte("c2")
+	if err := sqlitex.ExecNamed(conn, insert, nil, &row{A: "a2", B: 2, C: &blob}); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	fn := func(stmt *sqlite.Stmt) error {
+		got = append(got, fmt.Sprintf("%s:%d:%s", stmt.ColumnText(0), stmt.ColumnInt(1), stmt.ColumnText(2)))
+		return nil
+	}
+	err = sqlitex.ExecNamed(conn, "SELECT a, b, typeof(c) FROM t WHERE b >= $min ORDER BY b;", fn, map[string]interface{}{"min": 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a1:1:null", "a2:2:blob"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rows = %v, want %v", got, want)
+	}
+
+	err = sqlitex.ExecNamed(conn, insert, nil, map[string]interface{}{"a": "a3", "b": 3, "d": 4})
+	if err == nil || !strings.Contains(err.Error(), "missing parameters @c") || !strings.Contains(err.Error(), "unused parameters d") {
+		t.Errorf("missing and unused err = %v", err)
+	}
+	if err := sqlitex.ExecNamed(conn, "SELECT ?;", nil, nil); err == nil {
+		t.Error("nameless parameter did not return an error")
+	}
+	if err := sqlitex.ExecNamed(conn, "SELECT $x;", nil, 42); err == nil {
+		t.Error("invalid params type did not return an error")
+	}
+
+	var n int
+	fn = func(stmt *sqlite.Stmt) error {
+		n = stmt.ColumnInt(0)
+		return nil
+	}
+	if err := sqlitex.Exec(conn, "SELECT count(*) FROM t;", fn); err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("count = %d, want 2 after failed inserts", n)
+	}
+}
+
+// cents is stored as an INTEGER number of cents by BindSQLite.
+type cents float64
+
+func (c cents) BindSQLite(stmt *sqlite.Stmt, param int) error {
+	if c < 0 {
+		return errors.New("negative amount")
+	}
+	stmt.BindInt64(param, int64(c*100+0.5))
+	return nil
+}
+
+func (c *cents) ScanSQLite(stmt *sqlite.Stmt, col int) error {
+	*c = cents(stmt.ColumnInt64(col)) / 100
+	return nil
+}
+
+// userID is stored as text of the form "u123" by Value.
+type userID int
+
+func (id userID) Value() (driver.Value, error) {
+	return "u" + strconv.Itoa(int(id)), nil
+}
+
+func (id *userID) Scan(src interface{}) error {
+	s, ok := src.(string)
+	if !ok || !strings.HasPrefix(s, "u") {
+		return fmt.Errorf("bad user ID %v", src)
+	}
+	n, err := strconv.Atoi(s[1:])
+	*id = userID(n)
+	return err
+}
+
+// pair is stored as a two byte BLOB by MarshalBinary.
+type pair struct{ A, B byte }
+
+func (p pair) MarshalBinary() ([]byte, error) { return []byte{p.A, p.B}, nil }
+
+func (p *pair) UnmarshalBinary(b []byte) error {
+	if len(b) != 2 {
+		return errors.New("bad pair")
+	}
+	p.A, p.B = b[0], b[1]
+	return nil
+}
+
+func TestExecInterfaces(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var nilID *userID
+	id := userID(7)
+	err = sqlitex.Exec(conn, "CREATE TABLE t (amount, user, addr, pair, empty, ptr);", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = sqlitex.Exec(conn, "INSERT INTO t VALUES (?, ?, ?, ?, ?, ?);", nil,
+		cents(12.34), userID(5), net.IPv4(10, 0, 0, 1), pair{1, 2}, nilID, &id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var types []string
+	fn := func(stmt *sqlite.Stmt) error {
+		for col := 0; col < stmt.ColumnCount(); col++ {
+			types = append(types, stmt.ColumnType(col).String())
+		}
+		return nil
+	}
+	if err := sqlitex.Exec(conn, "SELECT * FROM t;", fn); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"SQLITE_INTEGER", "SQLITE_TEXT", "SQLITE_TEXT", "SQLITE_BLOB", "SQLITE_NULL", "SQLITE_TEXT"}
+	if !reflect.DeepEqual(types, want) {
+		t.Errorf("column types = %v, want %v", types, want)
+	}
+
+	var (
+		amount cents
+		user   userID
+		addr   net.IP
+		p      pair
+		none   *pair
+		ptr    *userID
+	)
+	fn = func(stmt *sqlite.Stmt) error {
+		return sqlitex.Scan(stmt, &amount, &user, &addr, &p, &none, &ptr)
+	}
+	if err := sqlitex.Exec(conn, "SELECT * FROM t;", fn); err != nil {
+		t.Fatal(err)
+	}
+	if amount != 12.34 || user != 5 || !addr.Equal(net.IPv4(10, 0, 0, 1)) || p != (pair{1, 2}) || none != nil || ptr == nil || *ptr != 7 {
+		t.Errorf("Scan got %v %v %v %v %v %v", amount, user, addr, p, none, ptr)
+	}
+
+	err = sqlitex.Exec(conn, "SELECT ?;", nil, cents(-1))
+	if err == nil || !strings.Contains(err.Error(), "negative amount") {
+		t.Errorf("BindSQLite error = %v, want negative amount", err)
+	}
+	err = sqlitex.Exec(conn, "SELECT 'x';", func(stmt *sqlite.Stmt) error {
+		return sqlitex.Scan(stmt, &user)
+	})
+	if _, ok := err.(*sqlitex.ScanError); !ok {
+		t.Errorf("sql.Scanner error = %v, want *sqlitex.ScanError", err)
+	}
+}
+
+func TestExecTimePointer(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetTimeFormat(sqlite.TimeFormatUnix)
+
+	tm := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	var nilTime *time.Time
+	var got []string
+	fn := func(stmt *sqlite.Stmt) error {
+		for col := 0; col < stmt.ColumnCount(); col++ {
+			got = append(got, stmt.ColumnText(col))
+		}
+		return nil
+	}
+	if err := sqlitex.Exec(conn, "SELECT ?, ?, typeof(?);", fn, tm, &tm, nilTime); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1577934245", "1577934245", "null"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Exec bound %q, want %q", got, want)
+	}
+
+	got = nil
+	params := struct {
+		T  time.Time  `sqlite:"t"`
+		TP *time.Time `sqlite:"tp"`
+	}{tm, &tm}
+	if err := sqlitex.ExecNamed(conn, "SELECT $t, $tp;", fn, params); err != nil {
+		t.Fatal(err)
+	}
+	if want := want[:2]; !reflect.DeepEqual(got, want) {
+		t.Errorf("ExecNamed bound %q, want %q", got, want)
+	}
+}
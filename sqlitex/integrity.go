@@ -0,0 +1,177 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"crawshaw.io/sqlite"
+)
+
+// An IntegrityProblem is one problem reported by IntegrityCheck.
+type IntegrityProblem struct {
+	// Message is the problem as reported by SQLite.
+	Message string
+
+	// Table and Index name the table and index the problem is in,
+	// when the message names them. Table is set for a problem with
+	// an index.
+	Table string
+	Index string
+
+	// Rowid is the row with the problem, or zero if the message
+	// does not name a row.
+	Rowid int64
+
+	// Page is the database page with the problem, or zero if the
+	// message does not name a page.
+	Page int
+}
+
+func (p IntegrityProblem) String() string { return p.Message }
+
+// IntegrityCheck checks the schema database on conn for corruption,
+// and returns the problems found. If the database is sound, it returns
+// no problems and a nil error. If schema is "", then "main" is used.
+//
+// With quick set, PRAGMA quick_check is run instead of integrity_check.
+// It does not check that indexes match their tables, so it runs in
+// O(N) rather than O(N log N) time.
+//
+// At most maxErrors problems are reported. A value of 0 or less
+// defaults to 100, as in SQLite.
+//
+// https://www.sqlite.org/pragma.html#pragma_integrity_check
+func IntegrityCheck(conn *sqlite.Conn, schema string, quick bool, maxErrors int) ([]IntegrityProblem, error) {
+	if schema == "" {
+		schema = "main"
+	}
+	if maxErrors <= 0 {
+		maxErrors = 100
+	}
+	pragma := "integrity_check"
+	if quick {
+		pragma = "quick_check"
+	}
+	query := fmt.Sprintf("PRAGMA %s.%s(%d);", quoteIdent(schema), pragma, maxErrors)
+
+	var problems []IntegrityProblem
+	err := ExecTransient(conn, query, func(stmt *sqlite.Stmt) error {
+		msg := stmt.ColumnText(0)
+		if msg == "ok" {
+			return nil
+		}
+		// The first problem in a database is prefixed by its name.
+		if strings.HasPrefix(msg, "*** in database ") {
+			if i := strings.IndexByte(msg, '\n'); i >= 0 {
+				msg = msg[i+1:]
+			}
+		}
+		problems = append(problems, parseIntegrityProblem(msg))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for i, p := range problems {
+		if p.Index == "" {
+			continue
+		}
+		stmt, err := conn.Prepare("SELECT tbl_name FROM " + quoteIdent(schema) + ".sqlite_master WHERE type = 'index' AND name = $name;")
+		if err != nil {
+			return nil, err
+		}
+		stmt.SetText("$name", p.Index)
+		if problems[i].Table, err = ResultText(stmt); err != nil {
+			return nil, err
+		}
+	}
+	return problems, nil
+}
+
+// parseIntegrityProblem parses a message from PRAGMA integrity_check.
+func parseIntegrityProblem(msg string) IntegrityProblem {
+	p := IntegrityProblem{Message: msg}
+	switch {
+	case strings.HasPrefix(msg, "row "):
+		// row N missing from index I
+		if i := strings.Index(msg, " missing from index "); i >= 0 {
+			p.Rowid, _ = strconv.ParseInt(msg[len("row "):i], 10, 64)
+			p.Index = msg[i+len(" missing from index "):]
+		}
+	case strings.HasPrefix(msg, "non-unique entry in index "):
+		p.Index = msg[len("non-unique entry in index "):]
+	case strings.HasPrefix(msg, "wrong # of entries in index "):
+		p.Index = msg[len("wrong # of entries in index "):]
+	case strings.HasPrefix(msg, "NULL value in "):
+		// NULL value in T.C
+		p.Table = msg[len("NULL value in "):]
+		if i := strings.LastIndexByte(p.Table, '.'); i >= 0 {
+			p.Table = p.Table[:i]
+		}
+	case strings.HasPrefix(msg, "CHECK constraint failed in "):
+		p.Table = msg[len("CHECK constraint failed in "):]
+	default:
+		// Page N: ..., On tree page N cell M: ..., On page N at ...
+		for _, prefix := range []string{"Page ", "On tree page ", "On page "} {
+			if strings.HasPrefix(msg, prefix) {
+				s := msg[len(prefix):]
+				end := 0
+				for end < len(s) && isDigit(s[end]) {
+					end++
+				}
+				p.Page, _ = strconv.Atoi(s[:end])
+				break
+			}
+		}
+	}
+	return p
+}
+
+// An FKViolation is a row that violates a foreign key constraint, as
+// reported by ForeignKeyCheck.
+type FKViolation struct {
+	Table  string // table holding the row
+	Rowid  int64  // rowid of the row, or zero for a WITHOUT ROWID table
+	Parent string // table the foreign key refers to
+	FKID   int    // id of the constraint, as in PRAGMA foreign_key_list
+}
+
+// ForeignKeyCheck reports the rows of all the tables on conn that
+// violate their foreign key constraints. It returns no violations and
+// a nil error if there are none.
+//
+// Foreign keys are checked whether or not PRAGMA foreign_keys is on.
+//
+// https://www.sqlite.org/pragma.html#pragma_foreign_key_check
+func ForeignKeyCheck(conn *sqlite.Conn) ([]FKViolation, error) {
+	var violations []FKViolation
+	err := ExecTransient(conn, "PRAGMA foreign_key_check;", func(stmt *sqlite.Stmt) error {
+		violations = append(violations, FKViolation{
+			Table:  stmt.ColumnText(0),
+			Rowid:  stmt.ColumnInt64(1),
+			Parent: stmt.ColumnText(2),
+			FKID:   stmt.ColumnInt(3),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return violations, nil
+}
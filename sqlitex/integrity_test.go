@@ -0,0 +1,148 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"crawshaw.io/sqlite"
+)
+
+func TestParseIntegrityProblem(t *testing.T) {
+	tests := []IntegrityProblem{
+		{Message: "row 42 missing from index t_c", Rowid: 42, Index: "t_c"},
+		{Message: "non-unique entry in index t_u", Index: "t_u"},
+		{Message: "wrong # of entries in index t_c", Index: "t_c"},
+		{Message: "NULL value in t.c", Table: "t"},
+		{Message: "CHECK constraint failed in t", Table: "t"},
+		{Message: "Page 7: btreeInitPage() returns error code 11", Page: 7},
+		{Message: "On tree page 3 cell 0: invalid page number 99", Page: 3},
+		{Message: "On page 12 at right child: 2nd reference to page 4", Page: 12},
+		{Message: "Page 5 is never used", Page: 5},
+		{Message: "Freelist: size is 3 but should be 2"},
+	}
+	for _, want := range tests {
+		if got := parseIntegrityProblem(want.Message); got != want {
+			t.Errorf("parseIntegrityProblem(%q) = %+v, want %+v", want.Message, got, want)
+		}
+	}
+}
+
+func TestIntegrityCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlitex-integrity-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "corrupt.db")
+	conn, err := sqlite.OpenConn(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ExecScript(conn, `
+		CREATE TABLE t (a INTEGER PRIMARY KEY, b, c);
+		CREATE INDEX t_c ON t (c);
+		INSERT INTO t (a, b, c) VALUES (1, 1, 10), (2, NULL, 20), (3, 3, 30);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, quick := range []bool{false, true} {
+		problems, err := IntegrityCheck(conn, "", quick, 0)
+		if err != nil || problems != nil {
+			t.Fatalf("quick=%v: IntegrityCheck of sound database = %v, %v", quick, problems, err)
+		}
+	}
+
+	// Change the schema under the data, so that b must not be NULL and
+	// the index on c claims to be on b.
+	err = ExecScript(conn, `
+		PRAGMA writable_schema = ON;
+		UPDATE sqlite_master SET sql = 'CREATE TABLE t (a INTEGER PRIMARY KEY, b NOT NULL, c)' WHERE name = 't';
+		UPDATE sqlite_master SET sql = 'CREATE INDEX t_c ON t (b)' WHERE name = 't_c';
+		PRAGMA writable_schema = OFF;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+	if conn, err = sqlite.OpenConn(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	problems, err := IntegrityCheck(conn, "main", false, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	has := func(problems []IntegrityProblem, want IntegrityProblem) bool {
+		for _, p := range problems {
+			if p == want {
+				return true
+			}
+		}
+		return false
+	}
+	for _, want := range []IntegrityProblem{
+		{Message: "NULL value in t.b", Table: "t"},
+		{Message: "row 1 missing from index t_c", Table: "t", Index: "t_c", Rowid: 1},
+		{Message: "row 3 missing from index t_c", Table: "t", Index: "t_c", Rowid: 3},
+	} {
+		if !has(problems, want) {
+			t.Errorf("integrity_check problems %+v do not include %+v", problems, want)
+		}
+	}
+
+	if problems, err := IntegrityCheck(conn, "", false, 1); err != nil || len(problems) != 1 {
+		t.Errorf("IntegrityCheck with maxErrors 1 = %+v, %v", problems, err)
+	}
+
+	// quick_check does not check indexes.
+	problems, err = IntegrityCheck(conn, "", true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []IntegrityProblem{{Message: "NULL value in t.b", Table: "t"}}; !reflect.DeepEqual(problems, want) {
+		t.Errorf("quick_check problems = %+v, want %+v", problems, want)
+	}
+}
+
+func TestForeignKeyCheck(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	err = ExecScript(conn, `
+		PRAGMA foreign_keys = OFF;
+		CREATE TABLE parent (id INTEGER PRIMARY KEY);
+		CREATE TABLE child (id INTEGER PRIMARY KEY, parent_id REFERENCES parent (id));
+		INSERT INTO parent (id) VALUES (1);
+		INSERT INTO child (id, parent_id) VALUES (10, 1), (11, 2), (12, NULL);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ForeignKeyCheck(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FKViolation{{Table: "child", Rowid: 11, Parent: "parent", FKID: 0}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ForeignKeyCheck = %+v, want %+v", got, want)
+	}
+}
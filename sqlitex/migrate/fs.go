@@ -0,0 +1,56 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+//go:build go1.16
+// +build go1.16
+
+package migrate
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+)
+
+// FromFS returns a migration for each .sql file in dir of fsys,
+// ordered by file name. The name of each migration is its file name,
+// so files are typically numbered:
+//
+//	//go:embed migrations
+//	var migrationFS embed.FS
+//
+//	migrations, err := migrate.FromFS(migrationFS, "migrations")
+//
+// where the migrations directory holds 0001_create_people.sql,
+// 0002_add_email.sql, and so on.
+func FromFS(fsys fs.FS, dir string) ([]Migration, error) {
+	names, err := fs.Glob(fsys, path.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, fmt.Errorf("migrate: %v", err)
+	}
+	sort.Strings(names)
+	var migrations []Migration
+	for _, name := range names {
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("migrate: %v", err)
+		}
+		migrations = append(migrations, Migration{
+			Name: path.Base(name),
+			SQL:  string(b),
+		})
+	}
+	return migrations, nil
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+//go:build go1.16
+// +build go1.16
+
+package migrate_test
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"crawshaw.io/sqlite/sqlitex/migrate"
+)
+
+func TestFromFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0002_insert.sql": {Data: []byte(`INSERT INTO t VALUES (1);`)},
+		"m/0001_create.sql": {Data: []byte(`CREATE TABLE t (c);`)},
+		"m/README":          {Data: []byte(`not a migration`)},
+	}
+	migrations, err := migrate.FromFS(fsys, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "0001_create.sql" || migrations[1].Name != "0002_insert.sql" {
+		t.Fatalf("migrations = %+v", migrations)
+	}
+
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err := migrate.Run(conn, migrations, nil); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := sqlitex.ResultInt(conn.Prep("SELECT count(*) FROM t;")); err != nil || n != 1 {
+		t.Errorf("count = %d, %v", n, err)
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+// Package migrate applies ordered schema migrations to an SQLite database.
+//
+// A migration is SQL text or a Go function. Migrations are applied in
+// order, each inside its own BEGIN IMMEDIATE transaction with foreign
+// key checks deferred to the end of the migration, and each is applied
+// at most once, even by runners on separate connections or in separate
+// processes. The number of applied migrations is recorded in the
+// database's user_version, or in a migrations table:
+//
+//	var migrations = []migrate.Migration{
+//		{Name: "create people", SQL: `CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT);`},
+//		{Name: "add email", SQL: `ALTER TABLE people ADD COLUMN email TEXT;`},
+//	}
+//
+//	dbpool, err := sqlitex.Open(uri, 0, 10)
+//	// ...
+//	if _, err := migrate.RunPool(ctx, dbpool, migrations, nil); err != nil {
+//		// ...
+//	}
+//
+// As a migration runs inside a transaction, it cannot change
+// PRAGMA foreign_keys. Enable foreign keys on the connection before
+// running migrations for the deferred checks to apply.
+//
+// Migrations must only ever be appended to the list. With a migrations
+// table, the SQL of each applied migration is checksummed so that
+// editing a migration after it has been applied is reported as an
+// error. The user_version has no room for checksums.
+package migrate // import "crawshaw.io/sqlite/sqlitex/migrate"
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+// Migration is a single schema change.
+// Exactly one of SQL and Func must be set.
+type Migration struct {
+	Name string // unique description, recorded in the migrations table
+	SQL  string // script run by sqlitex.ExecScript
+	Func func(conn *sqlite.Conn) error
+}
+
+// checksum returns the hex SHA-256 of the migration SQL,
+// or "" for a Func migration.
+func (m Migration) checksum() string {
+	if m.Func != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(m.SQL))
+	return hex.EncodeToString(sum[:])
+}
+
+// Options control how migrations are run.
+type Options struct {
+	// Table, if set, is the name of a table used to record applied
+	// migrations with their checksums. It is created if needed.
+	// If Table is empty, the number of applied migrations is
+	// recorded in PRAGMA user_version.
+	Table string
+
+	// DryRun reports the pending migrations without applying them.
+	// The database is not modified.
+	DryRun bool
+}
+
+// Run applies the pending migrations to conn in order. It reports the
+// names of the migrations applied, or with Options.DryRun the names of
+// the migrations that would be applied. A nil opts uses user_version.
+//
+// Each migration is applied in a transaction that takes the write lock
+// before reading which migrations have been applied, so concurrent
+// runners on the same database wait for one another and each migration
+// is applied exactly once. If conn is already in a transaction, each
+// migration is instead applied in a savepoint of it, and the caller is
+// responsible for holding the write lock.
+//
+// If a migration fails, its changes are rolled back and Run returns
+// the names of the migrations applied before it along with the error.
+//
+// Run is meant to be called once when a database is opened, before
+// the connections of a pool are used. See RunPool.
+func Run(conn *sqlite.Conn, migrations []Migration, opts *Options) (applied []string, err error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+	if err := validate(migrations); err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		version, err := readVersion(conn, opts, migrations)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range migrations[version:] {
+			applied = append(applied, m.Name)
+		}
+		return applied, nil
+	}
+
+	for {
+		name, err := applyNext(conn, opts, migrations)
+		if err != nil {
+			return applied, err
+		}
+		if name == "" {
+			return applied, nil
+		}
+		applied = append(applied, name)
+	}
+}
+
+// RunPool runs the migrations on one connection taken from pool.
+// Because the schema is part of the database, this is all a pool
+// needs: the other connections see the changes.
+func RunPool(ctx context.Context, pool *sqlitex.Pool, migrations []Migration, opts *Options) (applied []string, err error) {
+	conn := pool.Get(ctx)
+	if conn == nil {
+		if ctx != nil && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, errors.New("migrate: pool closed")
+	}
+	defer pool.Put(conn)
+	return Run(conn, migrations, opts)
+}
+
+func validate(migrations []Migration) error {
+	names := make(map[string]bool)
+	for i, m := range migrations {
+		if m.Name == "" {
+			return fmt.Errorf("migrate: migration %d has no name", i+1)
+		}
+		if names[m.Name] {
+			return fmt.Errorf("migrate: duplicate migration name %q", m.Name)
+		}
+		names[m.Name] = true
+		if (m.SQL == "") == (m.Func == nil) {
+			return fmt.Errorf("migrate: migration %q must have exactly one of SQL and Func", m.Name)
+		}
+	}
+	return nil
+}
+
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// readVersion reports how many of migrations have been applied.
+func readVersion(conn *sqlite.Conn, opts *Options, migrations []Migration) (int, error) {
+	if opts.Table != "" {
+		return checkTable(conn, opts, migrations)
+	}
+	version, err := sqlitex.ResultInt(conn.Prep("PRAGMA user_version;"))
+	if err != nil {
+		return 0, err
+	}
+	if version > len(migrations) {
+		return 0, fmt.Errorf("migrate: database user_version %d is newer than the %d known migrations", version, len(migrations))
+	}
+	return version, nil
+}
+
+// applyNext applies the first pending migration, and reports its name,
+// or "" if there are none.
+func applyNext(conn *sqlite.Conn, opts *Options, migrations []Migration) (name string, err error) {
+	if conn.GetAutocommit() {
+		// Take the write lock before reading the version, so that a
+		// concurrent runner waits for this migration and then sees it.
+		var endFn func(*error)
+		endFn, err = sqlitex.ImmediateTransaction(conn)
+		if err != nil {
+			return "", err
+		}
+		defer endFn(&err)
+	} else {
+		defer sqlitex.Save(conn)(&err)
+	}
+
+	version, err := readVersion(conn, opts, migrations)
+	if err != nil {
+		return "", err
+	}
+	if version == len(migrations) {
+		return "", nil
+	}
+	m := migrations[version]
+	if err := apply(conn, opts, version+1, m); err != nil {
+		return "", fmt.Errorf("migrate: %q: %v", m.Name, err)
+	}
+	return m.Name, nil
+}
+
+// checkTable checks the applied migrations recorded in the migrations
+// table against migrations, and reports how many have been applied.
+func checkTable(conn *sqlite.Conn, opts *Options, migrations []Migration) (version int, err error) {
+	exists := false
+	err = sqlitex.Exec(conn, "SELECT 1 FROM sqlite_master WHERE type = 'table' AND name = ?;", func(*sqlite.Stmt) error {
+		exists = true
+		return nil
+	}, opts.Table)
+	if err != nil {
+		return 0, err
+	}
+	if !exists {
+		if opts.DryRun {
+			return 0, nil
+		}
+		err := sqlitex.ExecTransient(conn, "CREATE TABLE "+quoteIdent(opts.Table)+` (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			checksum   TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		);`, nil)
+		return 0, err
+	}
+
+	fn := func(stmt *sqlite.Stmt) error {
+		v := stmt.ColumnInt(0)
+		name, checksum := stmt.ColumnText(1), stmt.ColumnText(2)
+		if v != version+1 {
+			return fmt.Errorf("migrate: table %s is missing version %d", opts.Table, version+1)
+		}
+		if v > len(migrations) {
+			return fmt.Errorf("migrate: database has migration %d (%q), newer than the %d known migrations", v, name, len(migrations))
+		}
+		m := migrations[v-1]
+		if m.Name != name {
+			return fmt.Errorf("migrate: migration %d is %q, but %q was applied", v, m.Name, name)
+		}
+		if sum := m.checksum(); sum != "" && checksum != "" && sum != checksum {
+			return fmt.Errorf("migrate: migration %q was modified after it was applied", name)
+		}
+		version = v
+		return nil
+	}
+	query := "SELECT version, name, checksum FROM " + quoteIdent(opts.Table) + " ORDER BY version;"
+	if err := sqlitex.ExecTransient(conn, query, fn); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// apply runs m as migration number version and records it.
+// It is called in a transaction.
+func apply(conn *sqlite.Conn, opts *Options, version int, m Migration) (err error) {
+	if err := sqlitex.ExecTransient(conn, "PRAGMA defer_foreign_keys = ON;", nil); err != nil {
+		return err
+	}
+	if m.Func != nil {
+		err = m.Func(conn)
+	} else {
+		err = sqlitex.ExecScript(conn, m.SQL)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.Table == "" {
+		return sqlitex.ExecTransient(conn, fmt.Sprintf("PRAGMA user_version = %d;", version), nil)
+	}
+	query := "INSERT INTO " + quoteIdent(opts.Table) + " (version, name, checksum, applied_at) VALUES (?, ?, ?, strftime('%Y-%m-%dT%H:%M:%fZ', 'now'));"
+	return sqlitex.Exec(conn, query, nil, version, m.Name, m.checksum())
+}
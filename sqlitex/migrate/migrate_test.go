@@ -0,0 +1,238 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package migrate_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+	"crawshaw.io/sqlite/sqlitex/migrate"
+)
+
+var migrations = []migrate.Migration{
+	{Name: "people", SQL: `CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT);`},
+	{Name: "pets", SQL: `CREATE TABLE pets (id INTEGER PRIMARY KEY, owner INTEGER REFERENCES people (id));`},
+	{Name: "seed", Func: func(conn *sqlite.Conn) error {
+		// The pet is inserted before its owner, which only works
+		// because foreign key checks are deferred.
+		return sqlitex.ExecScript(conn, `INSERT INTO pets (id, owner) VALUES (1, 1);
+			INSERT INTO people (id, name) VALUES (1, 'ann');`)
+	}},
+}
+
+func openConn(t *testing.T) *sqlite.Conn {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlitex.ExecTransient(conn, "PRAGMA foreign_keys = ON;", nil); err != nil {
+		conn.Close()
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func TestRunUserVersion(t *testing.T) {
+	conn := openConn(t)
+	defer conn.Close()
+
+	pending, err := migrate.Run(conn, migrations[:2], &migrate.Options{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"people", "pets"}; !reflect.DeepEqual(pending, want) {
+		t.Errorf("dry run = %q, want %q", pending, want)
+	}
+	if v, _ := sqlitex.ResultInt(conn.Prep("PRAGMA user_version;")); v != 0 {
+		t.Errorf("user_version after dry run = %d", v)
+	}
+
+	if _, err := migrate.Run(conn, migrations[:2], nil); err != nil {
+		t.Fatal(err)
+	}
+	applied, err := migrate.Run(conn, migrations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"seed"}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied = %q, want %q", applied, want)
+	}
+	if v, _ := sqlitex.ResultInt(conn.Prep("PRAGMA user_version;")); v != 3 {
+		t.Errorf("user_version = %d, want 3", v)
+	}
+	if applied, err := migrate.Run(conn, migrations, nil); err != nil || len(applied) != 0 {
+		t.Errorf("second run applied %q, %v", applied, err)
+	}
+	if _, err := migrate.Run(conn, migrations[:1], nil); err == nil {
+		t.Error("running older migrations succeeded")
+	}
+}
+
+func TestRunTable(t *testing.T) {
+	conn := openConn(t)
+	defer conn.Close()
+
+	opts := &migrate.Options{Table: "migrations"}
+	if _, err := migrate.Run(conn, migrations, opts); err != nil {
+		t.Fatal(err)
+	}
+	n, err := sqlitex.ResultInt(conn.Prep("SELECT count(*) FROM migrations WHERE length(applied_at) > 0;"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 {
+		t.Errorf("migrations table has %d rows, want 3", n)
+	}
+
+	edited := append([]migrate.Migration(nil), migrations...)
+	edited[0].SQL = `CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT, email TEXT);`
+	_, err = migrate.Run(conn, edited, &migrate.Options{Table: "migrations", DryRun: true})
+	if err == nil || !strings.Contains(err.Error(), "modified") {
+		t.Errorf("edited migration err = %v, want modified error", err)
+	}
+
+	renamed := append([]migrate.Migration(nil), migrations...)
+	renamed[1].Name = "animals"
+	if _, err := migrate.Run(conn, renamed, opts); err == nil {
+		t.Error("renamed migration succeeded")
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	conn := openConn(t)
+	defer conn.Close()
+
+	fail := errors.New("fail")
+	bad := append([]migrate.Migration(nil), migrations[:2]...)
+	bad = append(bad,
+		migrate.Migration{Name: "orphan", SQL: `INSERT INTO pets (id, owner) VALUES (1, 99);`},
+		migrate.Migration{Name: "never", Func: func(*sqlite.Conn) error { return fail }},
+	)
+	applied, err := migrate.Run(conn, bad, nil)
+	if err == nil {
+		t.Fatal("foreign key violation succeeded")
+	}
+	if want := []string{"people", "pets"}; !reflect.DeepEqual(applied, want) {
+		t.Errorf("applied = %q, want %q", applied, want)
+	}
+	if n, _ := sqlitex.ResultInt(conn.Prep("SELECT count(*) FROM pets;")); n != 0 {
+		t.Errorf("failed migration left %d pets", n)
+	}
+	if v, _ := sqlitex.ResultInt(conn.Prep("PRAGMA user_version;")); v != 2 {
+		t.Errorf("user_version = %d, want 2", v)
+	}
+
+	dup := []migrate.Migration{migrations[0], migrations[0]}
+	if _, err := migrate.Run(conn, dup, nil); err == nil {
+		t.Error("duplicate names succeeded")
+	}
+}
+
+func TestRunPool(t *testing.T) {
+	dbpool, err := sqlitex.Open("file::memory:?mode=memory&cache=shared", 0, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbpool.Close()
+
+	ctx := context.Background()
+	if _, err := migrate.RunPool(ctx, dbpool, migrations, nil); err != nil {
+		t.Fatal(err)
+	}
+	conn := dbpool.Get(ctx)
+	defer dbpool.Put(conn)
+	if n, err := sqlitex.ResultInt(conn.Prep("SELECT count(*) FROM people;")); err != nil || n != 1 {
+		t.Errorf("people count = %d, %v", n, err)
+	}
+}
+
+func TestRunConcurrent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "test.db")
+
+	const n = 5
+	var runs [n]int32
+	var ms []migrate.Migration
+	for i := 0; i < n; i++ {
+		i := i
+		ms = append(ms, migrate.Migration{
+			Name: fmt.Sprintf("m%d", i),
+			Func: func(conn *sqlite.Conn) error {
+				atomic.AddInt32(&runs[i], 1)
+				time.Sleep(time.Millisecond) // widen any race
+				return sqlitex.ExecTransient(conn, fmt.Sprintf("CREATE TABLE t%d (c);", i), nil)
+			},
+		})
+	}
+
+	for _, opts := range []*migrate.Options{nil, {Table: "migrations"}} {
+		os.Remove(path)
+		for i := range runs {
+			runs[i] = 0
+		}
+
+		// Each runner has its own connection, as separate processes would.
+		const runners = 4
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		var all []string
+		for r := 0; r < runners; r++ {
+			conn, err := sqlite.OpenConn(path, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer conn.Close()
+				applied, err := migrate.Run(conn, ms, opts)
+				if err != nil {
+					t.Errorf("Run: %v", err)
+				}
+				mu.Lock()
+				all = append(all, applied...)
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		sort.Strings(all)
+		if want := []string{"m0", "m1", "m2", "m3", "m4"}; !reflect.DeepEqual(all, want) {
+			t.Errorf("opts %+v: applied %v, want each of %v once", opts, all, want)
+		}
+		for i, r := range runs {
+			if r != 1 {
+				t.Errorf("opts %+v: migration %d ran %d times", opts, i, r)
+			}
+		}
+	}
+}
@@ -17,13 +17,21 @@ package sqlitex
 import (
 	"context"
 	"fmt"
+	"log"
+	"math/rand"
+	"runtime/debug"
 	"runtime/trace"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"crawshaw.io/sqlite"
 )
 
+// ErrPoolClosed is returned by Take when the Pool is closed.
+var ErrPoolClosed error = strerror{msg: "sqlitex: pool closed"}
+
 // Pool is a pool of SQLite connections.
 //
 // It is safe for use by multiple goroutines concurrently.
@@ -50,12 +58,159 @@ type Pool struct {
 	// TODO: export this? Is it enough of a performance concern?
 	checkReset bool
 
-	free   chan *sqlite.Conn
-	closed chan struct{}
+	uri  string
+	opts PoolOptions
+
+	closed  chan struct{}
+	drained chan struct{} // closed once Close has closed every Conn
 
-	all map[*sqlite.Conn]context.CancelFunc
+	mu        sync.Mutex
+	all       map[*sqlite.Conn]*connState
+	free      []*sqlite.Conn                     // idle Conns, most recently used last
+	waiters   [numPriorities][]chan *sqlite.Conn // blocked Gets by Priority, oldest first
+	pass      [numPriorities]int64               // weighted fair queuing virtual times
+	vtime     int64                              // pass of the last waiter woken
+	opening   int                                // Conns being opened outside mu
+	closing   []*sqlite.Conn                     // removed Conns for unlock to close
+	unclosed  int                                // removed Conns not yet closed
+	closeErr  error                              // first error from closing a Conn
+	stats     PoolStats                          // counters; sizes are filled in by Stats
+	isDrained bool
+}
 
-	mu sync.RWMutex
+// connState is the Pool's record of one of its Conns.
+type connState struct {
+	cancel   context.CancelFunc // set while the Conn is out of the Pool
+	created  time.Time
+	lastUsed time.Time
+	gotAt    time.Time    // when Get returned the Conn
+	stack    []byte       // stack of the Get call, if Debug is set
+	leaked   bool         // held for longer than LeakThreshold
+	temps    []tempObject // temp schema after initialization, if Scrub is set
+}
+
+// Priority is the priority of a caller of Pool.GetWithPriority.
+type Priority int
+
+const (
+	PriorityLow    Priority = iota // background work
+	PriorityNormal                 // the priority of Pool.Get
+	PriorityHigh                   // latency-sensitive work
+
+	numPriorities = 3
+)
+
+func (prio Priority) String() string {
+	switch prio {
+	case PriorityLow:
+		return "low"
+	case PriorityNormal:
+		return "normal"
+	case PriorityHigh:
+		return "high"
+	}
+	return fmt.Sprintf("Priority(%d)", int(prio))
+}
+
+// defaultWeights are the default PoolOptions.PriorityWeights.
+var defaultWeights = [numPriorities]int{1, 4, 16}
+
+// maxWeight bounds the priority weights used for fair queuing.
+const maxWeight = 1 << 20
+
+// PoolOptions configures a Pool opened with OpenPool.
+type PoolOptions struct {
+	// Flags are passed to sqlite.OpenConn. A value of 0 defaults to
+	// the flags documented in Open.
+	Flags sqlite.OpenFlags
+
+	// InitScript is run on each Conn when it is opened.
+	// See OpenInit.
+	InitScript string
+
+	// OnOpen, if set, is called on each Conn when it is opened,
+	// before InitScript, including Conns opened later by Get or to
+	// replace discarded ones. It is for setup that SQL cannot do,
+	// such as registering functions or setting a busy timeout.
+	// If OnOpen returns an error, the Conn is closed.
+	OnOpen func(conn *sqlite.Conn) error
+
+	// OnGet, if set, is called by Get before returning a Conn,
+	// with the context passed to Get.
+	OnGet func(ctx context.Context, conn *sqlite.Conn)
+
+	// OnPut, if set, is called by Put before returning a Conn to
+	// the Pool. If OnPut returns an error, the Conn is closed
+	// instead, and a new Conn is opened when one is needed.
+	OnPut func(conn *sqlite.Conn) error
+
+	// PriorityWeights are the relative shares of connections given
+	// to waiting Gets of each Priority, indexed by Priority. Missing
+	// or non-positive weights default to 1, 4 and 16 for PriorityLow,
+	// PriorityNormal and PriorityHigh. Weights are at most 1<<20.
+	PriorityWeights []int
+
+	// ReservedHigh connections are kept for PriorityHigh callers.
+	// Other Gets wait rather than take the last ReservedHigh
+	// connections of MaxSize, whether idle or yet to be opened.
+	// It must be less than MaxSize, so that other Gets can proceed.
+	ReservedHigh int
+
+	// QuickCheckRate is the fraction, from 0 to 1, of Puts that run
+	// PRAGMA quick_check on the connection before returning it to
+	// the Pool. A connection that fails the check is closed.
+	QuickCheckRate float64
+
+	// Scrub makes Put clean up after the caller instead of panicking
+	// or discarding the connection. It resets statements that are
+	// still running, rolls back an open transaction, detaches
+	// attached databases, drops temp tables, views, triggers and
+	// indexes created since InitScript, and clears the authorizer.
+	// Each cleanup is reported to Logf. A connection that cannot be
+	// cleaned is closed.
+	//
+	// As the authorizer is cleared, install authorizers in OnGet
+	// rather than OnOpen.
+	Scrub bool
+
+	// Logf, if set, is used to report Scrub cleanups and
+	// connections that Get fails to open. If nil, log.Printf is used.
+	Logf func(format string, v ...interface{})
+
+	// LeakThreshold, if positive, enables leak detection. Get
+	// records the stack of its caller, and a connection held for
+	// longer than LeakThreshold without being Put is reported to
+	// OnLeak, counted in PoolStats.Leaked and marked in the
+	// *ShutdownError of Shutdown.
+	LeakThreshold time.Duration
+
+	// OnLeak, if set, is called once for each connection held for
+	// longer than LeakThreshold. It is called from a background
+	// goroutine, and must not block.
+	OnLeak func(info ConnInfo)
+
+	// MinSize connections are opened by OpenPool, and the Pool
+	// keeps at least that many open.
+	//
+	// A shared-cache in-memory database is deleted when its last
+	// connection is closed, so pools of them need a MinSize of at
+	// least 1.
+	MinSize int
+
+	// MaxSize is the most connections the Pool will have open.
+	// Get opens new connections on demand up to MaxSize.
+	// If MaxSize is less than MinSize, it is MinSize.
+	MaxSize int
+
+	// IdleTimeout, if positive, is how long a connection may sit
+	// unused in the Pool before it is closed, as long as more than
+	// MinSize connections are open.
+	IdleTimeout time.Duration
+
+	// MaxLifetime, if positive, is how long a connection may be open.
+	// Connections older than this are closed when they are idle and
+	// replaced as needed.
+	MaxLifetime time.Duration
 }
 
 // Open opens a fixed-size pool of SQLite connections.
@@ -90,14 +245,53 @@ func Open(uri string, flags sqlite.OpenFlags, poolSize int) (pool *Pool, err err
 // do not run INSERT in any of the initScripts or else it may create duplicate
 // data unintentionally or fail.
 func OpenInit(ctx context.Context, uri string, flags sqlite.OpenFlags, poolSize int, initScript string) (pool *Pool, err error) {
+	return OpenPool(ctx, uri, PoolOptions{
+		Flags:      flags,
+		InitScript: initScript,
+		MinSize:    poolSize,
+		MaxSize:    poolSize,
+	})
+}
+
+// OpenPool opens a pool of SQLite connections that grows and shrinks
+// between opts.MinSize and opts.MaxSize.
+//
+// OpenPool opens MinSize connections before returning, so that errors
+// in the URI or InitScript are reported early. The ctx is used to
+// interrupt their InitScript. Further connections are opened by Get.
+func OpenPool(ctx context.Context, uri string, opts PoolOptions) (pool *Pool, err error) {
 	if uri == ":memory:" {
 		return nil, strerror{msg: `sqlite: ":memory:" does not work with multiple connections, use "file::memory:?mode=memory"`}
 	}
+	if opts.MaxSize < opts.MinSize {
+		opts.MaxSize = opts.MinSize
+	}
+	if opts.MaxSize <= 0 {
+		return nil, strerror{msg: "sqlitex: pool size must be positive"}
+	}
+	if opts.ReservedHigh < 0 || opts.ReservedHigh >= opts.MaxSize {
+		return nil, strerror{msg: "sqlitex: ReservedHigh must be between 0 and MaxSize-1"}
+	}
+
+	if opts.Flags == 0 {
+		opts.Flags = sqlite.SQLITE_OPEN_READWRITE |
+			sqlite.SQLITE_OPEN_CREATE |
+			sqlite.SQLITE_OPEN_WAL |
+			sqlite.SQLITE_OPEN_URI |
+			sqlite.SQLITE_OPEN_NOMUTEX
+	}
+
+	// sqlitex_pool is also defined in package sqlite
+	const sqlitex_pool = sqlite.OpenFlags(0x01000000)
+	opts.Flags |= sqlitex_pool
 
 	p := &Pool{
 		checkReset: true,
-		free:       make(chan *sqlite.Conn, poolSize),
+		uri:        uri,
+		opts:       opts,
 		closed:     make(chan struct{}),
+		drained:    make(chan struct{}),
+		all:        make(map[*sqlite.Conn]*connState),
 	}
 	defer func() {
 		// If an error occurred, call Close outside the lock so this doesn't deadlock.
@@ -106,44 +300,64 @@ func OpenInit(ctx context.Context, uri string, flags sqlite.OpenFlags, poolSize
 		}
 	}()
 
-	if flags == 0 {
-		flags = sqlite.SQLITE_OPEN_READWRITE |
-			sqlite.SQLITE_OPEN_CREATE |
-			sqlite.SQLITE_OPEN_WAL |
-			sqlite.SQLITE_OPEN_URI |
-			sqlite.SQLITE_OPEN_NOMUTEX
-	}
-
-	// sqlitex_pool is also defined in package sqlite
-	const sqlitex_pool = sqlite.OpenFlags(0x01000000)
-	flags |= sqlitex_pool
-
-	p.all = make(map[*sqlite.Conn]context.CancelFunc)
-	for i := 0; i < poolSize; i++ {
-		conn, err := sqlite.OpenConn(uri, flags)
+	for i := 0; i < opts.MinSize; i++ {
+		conn, st, err := p.open(ctx)
 		if err != nil {
 			return nil, err
 		}
-		p.free <- conn
-		p.all[conn] = func() {}
+		p.all[conn] = st
+		p.free = append(p.free, conn)
+	}
+
+	if interval := p.reapInterval(); interval > 0 {
+		go p.reap(interval)
+	}
+	return p, nil
+}
 
-		if initScript != "" {
+// open opens a new Conn and initializes it.
+func (p *Pool) open(ctx context.Context) (*sqlite.Conn, *connState, error) {
+	conn, err := sqlite.OpenConn(p.uri, p.opts.Flags)
+	if err != nil {
+		return nil, nil, err
+	}
+	if p.opts.OnOpen != nil {
+		if err := p.opts.OnOpen(conn); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	if p.opts.InitScript != "" {
+		if ctx != nil {
 			conn.SetInterrupt(ctx.Done())
-			if err := ExecScript(conn, initScript); err != nil {
-				return nil, err
-			}
-			conn.SetInterrupt(nil)
+		}
+		err := ExecScript(conn, p.opts.InitScript)
+		conn.SetInterrupt(nil)
+		if err != nil {
+			conn.Close()
+			return nil, nil, err
 		}
 	}
 
-	return p, nil
+	now := time.Now()
+	st := &connState{created: now, lastUsed: now}
+	if p.opts.Scrub {
+		if st.temps, err = tempObjects(conn); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	return conn, st, nil
 }
 
 // Get returns an SQLite connection from the Pool.
+// It is GetWithPriority with PriorityNormal.
 //
-// If no Conn is available, Get will block until one is, or until either the
-// Pool is closed or the context expires. If no Conn can be obtained, nil is
-// returned.
+// If no Conn is available and the Pool has fewer than its maximum
+// number of connections, Get opens a new one. Otherwise Get will block
+// until a Conn is returned, or until either the Pool is closed or the
+// context expires. If no Conn can be obtained, nil is returned; Take
+// reports why.
 //
 // The provided context is used to control the execution lifetime of the
 // connection. See Conn.SetInterrupt for details.
@@ -151,39 +365,177 @@ func OpenInit(ctx context.Context, uri string, flags sqlite.OpenFlags, poolSize
 // Applications must ensure that all non-nil Conns returned from Get are
 // returned to the same Pool with Put.
 func (p *Pool) Get(ctx context.Context) *sqlite.Conn {
+	return p.GetWithPriority(ctx, PriorityNormal)
+}
+
+// Take is Get, but reports why no Conn was returned: the error from
+// opening one, the context's error, or ErrPoolClosed.
+func (p *Pool) Take(ctx context.Context) (*sqlite.Conn, error) {
+	return p.TakeWithPriority(ctx, PriorityNormal)
+}
+
+// TakeWithPriority is GetWithPriority, but reports why no Conn was
+// returned, as Take does.
+func (p *Pool) TakeWithPriority(ctx context.Context, prio Priority) (*sqlite.Conn, error) {
+	return p.get(ctx, prio)
+}
+
+// GetWithPriority returns an SQLite connection from the Pool, as Get
+// does, for a caller of the given priority.
+//
+// When Gets of different priorities are waiting, returned connections
+// are shared between the priorities in proportion to their
+// PoolOptions.PriorityWeights, so that each priority makes progress
+// but higher ones are served more often. Within a priority, Gets are
+// served in order. Only PriorityHigh callers may use the last
+// PoolOptions.ReservedHigh connections.
+func (p *Pool) GetWithPriority(ctx context.Context, prio Priority) *sqlite.Conn {
+	conn, _ := p.get(ctx, prio)
+	return conn
+}
+
+func (p *Pool) get(ctx context.Context, prio Priority) (*sqlite.Conn, error) {
+	if prio < PriorityLow || prio > PriorityHigh {
+		panic(fmt.Sprintf("sqlitex.Pool.GetWithPriority: invalid priority %d", prio))
+	}
 	var tr sqlite.Tracer
 	if ctx != nil {
 		tr = &tracer{ctx: ctx}
 	} else {
 		ctx = context.Background()
 	}
-	var cancel context.CancelFunc
-	ctx, cancel = context.WithCancel(ctx)
 
-outer:
-	select {
-	case conn := <-p.free:
-		p.mu.Lock()
-		defer p.mu.Unlock()
+	var stack []byte
+	if Debug || p.opts.LeakThreshold > 0 {
+		stack = debug.Stack()
+	}
+
+	var waitStart time.Time
+	defer func() {
+		if !waitStart.IsZero() {
+			p.mu.Lock()
+			p.stats.WaitDuration += time.Since(waitStart)
+			p.mu.Unlock()
+		}
+	}()
+
+	retry := false
+	p.mu.Lock()
+	p.stats.Gets++
+	for {
+		if p.isClosed() {
+			p.unlock()
+			return nil, ErrPoolClosed
+		}
+
+		if n := len(p.free); n > 0 && p.canTake(prio, 0) {
+			conn := p.free[n-1]
+			p.free = p.free[:n-1]
+			if p.expired(p.all[conn], time.Now()) {
+				p.remove(conn)
+				continue
+			}
+			return p.checkout(ctx, tr, stack, conn)
+		}
+
+		if len(p.all)+p.opening < p.opts.MaxSize && p.canTake(prio, 0) {
+			p.opening++
+			p.unlock()
+			conn, st, err := p.open(ctx)
+			p.mu.Lock()
+			p.opening--
+			if err != nil {
+				p.stats.OpenErrors++
+				// Give a waiter the chance to open a Conn.
+				p.wake(nil)
+				p.checkDrained()
+				p.unlock()
+				p.logf("sqlitex: Pool.Get: opening connection: %v", err)
+				return nil, err
+			}
+			p.all[conn] = st
+			return p.checkout(ctx, tr, stack, conn)
+		}
+
+		if waitStart.IsZero() {
+			waitStart = time.Now()
+			p.stats.Waits++
+		}
+		ch := make(chan *sqlite.Conn, 1)
+		if len(p.waiters[prio]) == 0 && p.pass[prio] < p.vtime {
+			// Do not let an idle priority build up credit.
+			p.pass[prio] = p.vtime
+		}
+		if retry {
+			// Woken to try again, this Get was at the head of the
+			// queue. Keep its place there.
+			p.waiters[prio] = append([]chan *sqlite.Conn{ch}, p.waiters[prio]...)
+		} else {
+			p.waiters[prio] = append(p.waiters[prio], ch)
+		}
+		p.unlock()
 
 		select {
+		case conn := <-ch:
+			p.mu.Lock()
+			if conn != nil {
+				return p.checkout(ctx, tr, stack, conn)
+			}
+			retry = true
+			continue // a slot opened up, try again
+		case <-ctx.Done():
 		case <-p.closed:
-			p.free <- conn
-			break outer
-		default:
 		}
 
-		conn.SetTracer(tr)
-		conn.SetInterrupt(ctx.Done())
+		p.mu.Lock()
+		err := ctx.Err()
+		switch err {
+		case context.DeadlineExceeded:
+			p.stats.Timeouts++
+		case context.Canceled:
+			p.stats.Cancels++
+		}
+		if !p.dequeue(prio, ch) {
+			// A Conn was handed to us as we gave up. Pass it on.
+			if conn := <-ch; conn != nil {
+				p.release(conn)
+			} else {
+				p.wake(nil)
+			}
+		}
+		p.unlock()
+		if err == nil {
+			err = ErrPoolClosed
+		}
+		return nil, err
+	}
+}
 
-		p.all[conn] = cancel
+// checkout hands conn to the caller of Get.
+// It is called with p.mu held and unlocks it.
+func (p *Pool) checkout(ctx context.Context, tr sqlite.Tracer, stack []byte, conn *sqlite.Conn) (*sqlite.Conn, error) {
+	if p.isClosed() {
+		p.release(conn)
+		p.unlock()
+		return nil, ErrPoolClosed
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	st := p.all[conn]
+	st.cancel = cancel
+	st.gotAt = time.Now()
+	st.stack = stack
+	st.leaked = false
+	if p.stats.InUse++; p.stats.InUse > p.stats.MaxInUse {
+		p.stats.MaxInUse = p.stats.InUse
+	}
+	p.unlock()
 
-		return conn
-	case <-ctx.Done():
-	case <-p.closed:
+	conn.SetTracer(tr)
+	conn.SetInterrupt(ctx.Done())
+	if p.opts.OnGet != nil {
+		p.opts.OnGet(ctx, conn)
 	}
-	cancel()
-	return nil
+	return conn, nil
 }
 
 // Put puts an SQLite connection back into the Pool.
@@ -191,13 +543,20 @@ outer:
 // Put will panic if conn is nil or if the conn was not originally created by
 // p.
 //
+// A connection that has failed with SQLITE_CORRUPT, SQLITE_IOERR,
+// SQLITE_NOMEM or SQLITE_NOTADB, or that was left with a transaction
+// open, is closed rather than reused. That includes the read
+// transaction of a transient statement that was stepped but not
+// finalized. A new connection is
+// opened in its place when one is needed.
+//
 // Applications must ensure that all non-nil Conns returned from Get are
 // returned to the same Pool with Put.
 func (p *Pool) Put(conn *sqlite.Conn) {
 	if conn == nil {
 		panic("attempted to Put a nil Conn into Pool")
 	}
-	if p.checkReset {
+	if p.checkReset && !p.opts.Scrub {
 		query := conn.CheckReset()
 		if query != "" {
 			panic(fmt.Sprintf(
@@ -205,17 +564,309 @@ func (p *Pool) Put(conn *sqlite.Conn) {
 				query))
 		}
 	}
+	st := p.checkedOut("Put", conn)
 
-	p.mu.RLock()
-	cancel, found := p.all[conn]
-	p.mu.RUnlock()
+	discard := false
+	if p.opts.Scrub {
+		discard = !p.scrub(conn, st)
+	}
+	discard = discard || !p.healthy(conn)
+	if !discard && p.opts.OnPut != nil {
+		discard = p.opts.OnPut(conn) != nil
+	}
+	p.put(st, conn, discard)
+}
+
+// Discard closes a connection returned by Get instead of putting it
+// back into the Pool. A new connection is opened in its place when
+// one is needed.
+//
+// Discard will panic if conn is nil or if the conn was not originally
+// created by p.
+func (p *Pool) Discard(conn *sqlite.Conn) {
+	if conn == nil {
+		panic("attempted to Discard a nil Conn from Pool")
+	}
+	p.put(p.checkedOut("Discard", conn), conn, true)
+}
+
+// checkedOut returns the state of conn, which must have come from Get.
+func (p *Pool) checkedOut(method string, conn *sqlite.Conn) *connState {
+	p.mu.Lock()
+	st, found := p.all[conn]
+	inUse := found && st.cancel != nil
+	p.mu.Unlock()
 
 	if !found {
-		panic("sqlite.Pool.Put: connection not created by this pool")
+		panic("sqlite.Pool." + method + ": connection not created by this pool")
+	}
+	if !inUse {
+		panic("sqlite.Pool." + method + ": connection already in pool")
+	}
+	return st
+}
+
+// healthy reports whether conn can be reused.
+func (p *Pool) healthy(conn *sqlite.Conn) bool {
+	switch conn.LastErrorCode() & 0xff { // primary result code
+	case sqlite.SQLITE_CORRUPT, sqlite.SQLITE_IOERR, sqlite.SQLITE_NOMEM, sqlite.SQLITE_NOTADB:
+		return false
+	}
+	if !conn.GetAutocommit() || conn.TxnState("") != sqlite.SQLITE_TXN_NONE {
+		// Inside a transaction, which BEGIN DEFERRED starts without
+		// a lock, or a statement that was not reset or finalized
+		// holds a read transaction open.
+		return false
+	}
+	if rate := p.opts.QuickCheckRate; rate > 0 && rand.Float64() < rate {
+		// The Get context may be done by now. Run the check regardless.
+		conn.SetInterrupt(nil)
+		result, err := ResultText(conn.Prep("PRAGMA quick_check;"))
+		if err != nil || result != "ok" {
+			return false
+		}
+	}
+	return true
+}
+
+// put returns conn from Get to the Pool, or closes it if discard.
+func (p *Pool) put(st *connState, conn *sqlite.Conn, discard bool) {
+	p.mu.Lock()
+	defer p.unlock()
+
+	st.cancel()
+	st.cancel = nil
+	st.stack = nil
+	p.stats.InUse--
+	if discard {
+		p.stats.Discards++
+		p.remove(conn)
+		p.wake(nil)
+		return
+	}
+	p.release(conn)
+}
+
+// release returns conn to the free list or to a waiting Get, or closes
+// it if the Pool is closed or conn is past its lifetime.
+// It is called with p.mu held.
+func (p *Pool) release(conn *sqlite.Conn) {
+	st := p.all[conn]
+	st.lastUsed = time.Now()
+	if p.isClosed() || p.expired(st, st.lastUsed) {
+		p.remove(conn)
+		p.wake(nil)
+		return
 	}
+	p.wake(conn)
+}
 
-	cancel()
-	p.free <- conn
+// wake hands conn to the next waiting Get, chosen by priority, or if
+// there is none that may take it, adds it to the free list. A nil
+// conn tells the waiter to try again.
+// It is called with p.mu held.
+func (p *Pool) wake(conn *sqlite.Conn) {
+	extra := 0
+	if conn != nil {
+		extra = 1
+	}
+	best := -1
+	for prio := numPriorities - 1; prio >= 0; prio-- { // ties go to higher priorities
+		if len(p.waiters[prio]) == 0 || !p.canTake(Priority(prio), extra) {
+			continue
+		}
+		if best == -1 || p.pass[prio] < p.pass[best] {
+			best = prio
+		}
+	}
+	if best == -1 {
+		if conn != nil {
+			p.free = append(p.free, conn)
+		}
+		return
+	}
+
+	p.vtime = p.pass[best]
+	p.pass[best] += maxWeight / int64(p.weight(Priority(best)))
+	ch := p.waiters[best][0]
+	p.waiters[best] = p.waiters[best][1:]
+	ch <- conn
+}
+
+// canTake reports whether a Get of priority prio may take a
+// connection, given extra connections not yet in the free list.
+// It is called with p.mu held.
+func (p *Pool) canTake(prio Priority, extra int) bool {
+	if prio == PriorityHigh {
+		return true
+	}
+	available := len(p.free) + extra + p.opts.MaxSize - len(p.all) - p.opening
+	return available > p.opts.ReservedHigh
+}
+
+func (p *Pool) weight(prio Priority) int {
+	if int(prio) < len(p.opts.PriorityWeights) {
+		if w := p.opts.PriorityWeights[prio]; w > maxWeight {
+			return maxWeight
+		} else if w > 0 {
+			return w
+		}
+	}
+	return defaultWeights[prio]
+}
+
+// dequeue removes ch from the waiters of priority prio.
+// It reports false if ch was already woken.
+// It is called with p.mu held.
+func (p *Pool) dequeue(prio Priority, ch chan *sqlite.Conn) bool {
+	for i, w := range p.waiters[prio] {
+		if w == ch {
+			p.waiters[prio] = append(p.waiters[prio][:i], p.waiters[prio][i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// remove forgets conn and queues it to be closed by unlock.
+// It is called with p.mu held.
+func (p *Pool) remove(conn *sqlite.Conn) {
+	delete(p.all, conn)
+	p.closing = append(p.closing, conn)
+	p.unclosed++
+}
+
+// unlock unlocks p.mu, first closing the Conns queued by remove.
+// Closing a Conn may wait on SQLite, so it is done without p.mu held.
+func (p *Pool) unlock() {
+	for len(p.closing) > 0 {
+		conns := p.closing
+		p.closing = nil
+		p.mu.Unlock()
+
+		var err error
+		for _, conn := range conns {
+			if cerr := conn.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+
+		p.mu.Lock()
+		if err != nil && p.closeErr == nil {
+			p.closeErr = err
+		}
+		p.unclosed -= len(conns)
+		p.checkDrained()
+	}
+	p.mu.Unlock()
+}
+
+func (p *Pool) expired(st *connState, now time.Time) bool {
+	return p.opts.MaxLifetime > 0 && now.Sub(st.created) > p.opts.MaxLifetime
+}
+
+func (p *Pool) isClosed() bool {
+	select {
+	case <-p.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// checkDrained signals Close once the last Conn is closed.
+// It is called with p.mu held.
+func (p *Pool) checkDrained() {
+	if p.isClosed() && !p.isDrained && len(p.all) == 0 && p.opening == 0 && p.unclosed == 0 {
+		p.isDrained = true
+		close(p.drained)
+	}
+}
+
+// reapInterval reports how often to look for idle or expired
+// connections, or 0 if they are kept indefinitely.
+// logf reports to PoolOptions.Logf, or log.Printf.
+func (p *Pool) logf(format string, v ...interface{}) {
+	if p.opts.Logf != nil {
+		p.opts.Logf(format, v...)
+	} else {
+		log.Printf(format, v...)
+	}
+}
+
+func (p *Pool) reapInterval() time.Duration {
+	d := p.opts.IdleTimeout
+	for _, l := range []time.Duration{p.opts.MaxLifetime, p.opts.LeakThreshold} {
+		if l > 0 && (d <= 0 || l < d) {
+			d = l
+		}
+	}
+	return d / 2
+}
+
+// reap periodically closes idle and expired connections, opens
+// replacements to keep MinSize connections open, and reports leaks.
+func (p *Pool) reap(interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.closed:
+			return
+		case <-t.C:
+		}
+
+		p.mu.Lock()
+		now := time.Now()
+		free := p.free
+		p.free = p.free[:0]
+		for _, conn := range free {
+			st := p.all[conn]
+			idle := p.opts.IdleTimeout > 0 && now.Sub(st.lastUsed) > p.opts.IdleTimeout
+			if (idle && len(p.all) > p.opts.MinSize) || p.expired(st, now) {
+				p.remove(conn)
+				continue
+			}
+			p.free = append(p.free, conn)
+		}
+		for !p.isClosed() && len(p.all)+p.opening < p.opts.MinSize {
+			p.opening++
+			p.unlock()
+			conn, st, err := p.open(nil)
+			p.mu.Lock()
+			p.opening--
+			if err != nil {
+				break
+			}
+			p.all[conn] = st
+			p.release(conn)
+		}
+		leaks := p.findLeaks(now)
+		p.checkDrained()
+		p.unlock()
+
+		if p.opts.OnLeak != nil {
+			for _, info := range leaks {
+				p.opts.OnLeak(info)
+			}
+		}
+	}
+}
+
+// findLeaks marks the Conns held for longer than LeakThreshold,
+// and reports those newly marked.
+// It is called with p.mu held.
+func (p *Pool) findLeaks(now time.Time) (leaks []ConnInfo) {
+	if p.opts.LeakThreshold <= 0 {
+		return nil
+	}
+	for _, st := range p.all {
+		if st.cancel != nil && !st.leaked && now.Sub(st.gotAt) > p.opts.LeakThreshold {
+			st.leaked = true
+			leaks = append(leaks, st.info())
+		}
+	}
+	return leaks
 }
 
 // PoolCloseTimeout is the maximum time for Pool.Close to wait for all Conns to
@@ -229,31 +880,110 @@ var PoolCloseTimeout = 5 * time.Second
 // Close blocks until all connections are returned to the Pool.
 //
 // Close will panic if not all connections are returned before
-// PoolCloseTimeout.
+// PoolCloseTimeout. Use Shutdown to wait without panicking.
 func (p *Pool) Close() (err error) {
-	close(p.closed)
+	ctx, cancel := context.WithTimeout(context.Background(), PoolCloseTimeout)
+	defer cancel()
+	err = p.Shutdown(ctx)
+	if _, timeout := err.(*ShutdownError); timeout {
+		panic("not all connections returned to Pool before timeout")
+	}
+	return err
+}
 
-	p.mu.RLock()
-	for _, cancel := range p.all {
-		cancel()
+// Shutdown closes the Pool. Gets return nil from then on, and the
+// connections in use are interrupted, as with Conn.SetInterrupt.
+//
+// Shutdown waits for all connections to be returned and closed, or
+// until ctx is done. In the latter case it returns a *ShutdownError
+// describing the connections still in use, which are closed when
+// they are eventually Put.
+//
+// Shutdown may be called more than once, for example to wait again
+// with a new context.
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	if !p.isClosed() {
+		close(p.closed)
+	}
+	for _, st := range p.all {
+		if st.cancel != nil {
+			st.cancel()
+		}
+	}
+	free := p.free
+	p.free = nil
+	for _, conn := range free {
+		p.remove(conn)
 	}
-	p.mu.RUnlock()
+	p.checkDrained()
+	p.unlock()
 
-	timeout := time.After(PoolCloseTimeout)
-	for closed := 0; closed < len(p.all); closed++ {
-		select {
-		case conn := <-p.free:
-			err2 := conn.Close()
-			if err == nil {
-				err = err2
-			}
-		case <-timeout:
-			panic("not all connections returned to Pool before timeout")
+	select {
+	case <-p.drained:
+	case <-ctx.Done():
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if p.isDrained {
+			return p.closeErr
 		}
+		return &ShutdownError{Err: ctx.Err(), Conns: p.inUse()}
 	}
-	return
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.closeErr
+}
+
+// ConnInfo describes a connection returned by Get that has not been Put.
+type ConnInfo struct {
+	Since  time.Time // when Get returned the connection
+	Stack  string    // the stack of the Get call, if Debug or LeakThreshold is set
+	Leaked bool      // held for longer than LeakThreshold
 }
 
+func (st *connState) info() ConnInfo {
+	return ConnInfo{Since: st.gotAt, Stack: string(st.stack), Leaked: st.leaked}
+}
+
+// inUse reports on the Conns that are out of the Pool, oldest first.
+// It is called with p.mu held.
+func (p *Pool) inUse() []ConnInfo {
+	var conns []ConnInfo
+	for _, st := range p.all {
+		if st.cancel != nil {
+			conns = append(conns, st.info())
+		}
+	}
+	sort.Slice(conns, func(i, j int) bool { return conns[i].Since.Before(conns[j].Since) })
+	return conns
+}
+
+// ShutdownError is returned by Pool.Shutdown when its context is done
+// before all connections are returned.
+type ShutdownError struct {
+	Err   error      // the context error
+	Conns []ConnInfo // the connections still in use
+}
+
+func (err *ShutdownError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "sqlitex: Pool.Shutdown: %d connections still in use: %v", len(err.Conns), err.Err)
+	for _, c := range err.Conns {
+		fmt.Fprintf(&b, "\n\tsince %s", c.Since.Format(time.RFC3339Nano))
+		if c.Leaked {
+			b.WriteString(" (leaked)")
+		}
+		if c.Stack != "" {
+			fmt.Fprintf(&b, ", from Get at:\n%s", c.Stack)
+		}
+	}
+	return b.String()
+}
+
+// Unwrap returns the context error.
+func (err *ShutdownError) Unwrap() error { return err.Err }
+
 type strerror struct {
 	msg string
 }
@@ -0,0 +1,629 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+// openTestPool opens a Pool on a new database file.
+func openTestPool(t *testing.T, opts PoolOptions) (pool *Pool, cleanup func()) {
+	dir, err := ioutil.TempDir("", "sqlitex-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err = OpenPool(nil, filepath.Join(dir, "pool.db"), opts)
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return pool, func() {
+		if err := pool.Close(); err != nil {
+			t.Error(err)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// sizes reports the number of open and idle connections in p.
+func (p *Pool) sizes() (open, idle int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.all), len(p.free)
+}
+
+func TestPoolElastic(t *testing.T) {
+	p, cleanup := openTestPool(t, PoolOptions{
+		MinSize:     1,
+		MaxSize:     3,
+		IdleTimeout: 20 * time.Millisecond,
+	})
+	defer cleanup()
+	ctx := context.Background()
+
+	if open, idle := p.sizes(); open != 1 || idle != 1 {
+		t.Fatalf("after open: %d open, %d idle, want 1, 1", open, idle)
+	}
+
+	var conns []*sqlite.Conn
+	for i := 0; i < 3; i++ {
+		conns = append(conns, p.Get(ctx))
+	}
+	if open, _ := p.sizes(); open != 3 {
+		t.Errorf("after Get: %d open, want 3", open)
+	}
+
+	// At MaxSize, Get waits for a Put.
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	if conn := p.Get(cctx); conn != nil {
+		t.Error("Get beyond MaxSize returned a Conn")
+	}
+	cancel()
+	got := make(chan *sqlite.Conn)
+	go func() { got <- p.Get(ctx) }()
+	time.Sleep(10 * time.Millisecond)
+	p.Put(conns[0])
+	if conn := <-got; conn != conns[0] {
+		t.Error("waiting Get did not receive the Put Conn")
+	}
+
+	for _, conn := range conns {
+		p.Put(conn)
+	}
+	deadline := time.Now().Add(time.Second)
+	for {
+		open, idle := p.sizes()
+		if open == 1 && idle == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("idle connections not reaped: %d open, %d idle", open, idle)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoolMaxLifetime(t *testing.T) {
+	p, cleanup := openTestPool(t, PoolOptions{
+		MinSize:     2,
+		MaxLifetime: 20 * time.Millisecond,
+	})
+	defer cleanup()
+
+	first := p.Get(nil)
+	time.Sleep(30 * time.Millisecond)
+	p.Put(first)
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		p.mu.Lock()
+		_, stale := p.all[first]
+		n := len(p.all)
+		p.mu.Unlock()
+		if !stale && n == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expired connection not replaced: stale=%v, %d open", stale, n)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoolStats(t *testing.T) {
+	p, cleanup := openTestPool(t, PoolOptions{MinSize: 1, MaxSize: 2})
+	defer cleanup()
+	ctx := context.Background()
+
+	c0 := p.Get(ctx)
+	c1 := p.Get(ctx)
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	if conn := p.Get(cctx); conn != nil {
+		t.Fatal("Get beyond MaxSize returned a Conn")
+	}
+	cancel()
+	cctx, cancel = context.WithCancel(ctx)
+	cancel()
+	if conn := p.Get(cctx); conn != nil {
+		t.Fatal("Get with cancelled context returned a Conn")
+	}
+	p.Put(c1)
+
+	stats := p.Stats()
+	want := PoolStats{Open: 2, Idle: 1, InUse: 1, MaxInUse: 2, Gets: 4, Waits: 2, Timeouts: 1, Cancels: 1}
+	if stats.WaitDuration < 10*time.Millisecond {
+		t.Errorf("WaitDuration = %v, want at least 10ms", stats.WaitDuration)
+	}
+	stats.WaitDuration = 0
+	if stats != want {
+		t.Errorf("Stats = %+v, want %+v", stats, want)
+	}
+	p.Put(c0)
+
+	var got PoolStats
+	if err := json.Unmarshal([]byte(p.StatsVar().String()), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Idle != 2 || got.Gets != 4 {
+		t.Errorf("StatsVar = %s", p.StatsVar())
+	}
+}
+
+func TestPoolHooks(t *testing.T) {
+	opened := 0
+	var gotCtx context.Context
+	p, cleanup := openTestPool(t, PoolOptions{
+		MinSize: 1,
+		MaxSize: 2,
+		OnOpen: func(conn *sqlite.Conn) error {
+			opened++
+			return conn.CreateFunction("answer", true, 0, func(ctx sqlite.Context, _ ...sqlite.Value) {
+				ctx.ResultInt(42)
+			}, nil, nil)
+		},
+		OnGet: func(ctx context.Context, conn *sqlite.Conn) {
+			gotCtx = ctx
+		},
+		OnPut: func(conn *sqlite.Conn) error {
+			// Reject connections that were left with a temp table.
+			n, err := ResultInt(conn.Prep("SELECT count(*) FROM sqlite_temp_master;"))
+			if err == nil && n > 0 {
+				err = errors.New("dirty connection")
+			}
+			return err
+		},
+	})
+	defer cleanup()
+
+	type key struct{}
+	ctx := context.WithValue(context.Background(), key{}, 1)
+	c0 := p.Get(ctx)
+	if gotCtx == nil || gotCtx.Value(key{}) != 1 {
+		t.Error("OnGet did not receive the Get context")
+	}
+	c1 := p.Get(ctx) // opened by Get
+	for _, conn := range []*sqlite.Conn{c0, c1} {
+		if n, err := ResultInt(conn.Prep("SELECT answer();")); err != nil || n != 42 {
+			t.Errorf("answer() = %d, %v", n, err)
+		}
+	}
+	if err := ExecTransient(c1, "CREATE TEMP TABLE scratch (c);", nil); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(c0)
+	p.Put(c1)
+
+	p.mu.Lock()
+	_, kept := p.all[c1]
+	p.mu.Unlock()
+	if kept {
+		t.Error("connection rejected by OnPut was kept")
+	}
+	c2 := p.Get(ctx)
+	c3 := p.Get(ctx)
+	if opened != 3 {
+		t.Errorf("opened %d connections, want 3", opened)
+	}
+	if n, err := ResultInt(c3.Prep("SELECT answer();")); err != nil || n != 42 {
+		t.Errorf("replacement answer() = %d, %v", n, err)
+	}
+	p.Put(c2)
+	p.Put(c3)
+}
+
+func TestPoolOpenError(t *testing.T) {
+	errOpen := errors.New("no more connections")
+	fail := false
+	var logged []string
+	p, cleanup := openTestPool(t, PoolOptions{
+		MinSize: 1,
+		MaxSize: 2,
+		OnOpen: func(conn *sqlite.Conn) error {
+			if fail {
+				return errOpen
+			}
+			return nil
+		},
+		Logf: func(format string, v ...interface{}) {
+			logged = append(logged, fmt.Sprintf(format, v...))
+		},
+	})
+	defer cleanup()
+
+	c0, err := p.Take(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fail = true
+	if conn, err := p.Take(nil); conn != nil || err != errOpen {
+		t.Errorf("Take = %v, %v, want %v", conn, err, errOpen)
+	}
+	if conn := p.Get(nil); conn != nil {
+		t.Error("Get returned a connection that failed to open")
+	}
+	if n := p.Stats().OpenErrors; n != 2 {
+		t.Errorf("OpenErrors = %d, want 2", n)
+	}
+	if len(logged) != 2 || !strings.Contains(logged[0], errOpen.Error()) {
+		t.Errorf("logged %q, want the open error twice", logged)
+	}
+
+	fail = false
+	c1, err := p.Take(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if conn, err := p.Take(ctx); conn != nil || err != context.Canceled {
+		t.Errorf("Take from a full Pool = %v, %v, want context.Canceled", conn, err)
+	}
+	p.Put(c0)
+	p.Put(c1)
+}
+
+func TestPoolHealth(t *testing.T) {
+	p, cleanup := openTestPool(t, PoolOptions{MinSize: 1, MaxSize: 2, QuickCheckRate: 1})
+	defer cleanup()
+
+	isOpen := func(conn *sqlite.Conn) bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		_, ok := p.all[conn]
+		return ok
+	}
+
+	conn := p.Get(nil)
+	if err := ExecTransient(conn, "CREATE TABLE t (c);", nil); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+	if !isOpen(conn) {
+		t.Fatal("healthy connection was closed")
+	}
+
+	conn = p.Get(nil)
+	if err := ExecTransient(conn, "BEGIN;", nil); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+	if isOpen(conn) {
+		t.Error("connection left in a transaction was kept")
+	}
+
+	// A transient statement that is not finalized keeps a read
+	// transaction open, though the connection is in autocommit mode.
+	conn = p.Get(nil)
+	stmt, _, err := conn.PrepareTransient("SELECT 1 FROM t UNION ALL SELECT 2;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stmt.Step(); err != nil {
+		t.Fatal(err)
+	}
+	if p.healthy(conn) {
+		t.Error("connection with a stepped transient statement is healthy")
+	}
+	if err := stmt.Finalize(); err != nil {
+		t.Fatal(err)
+	}
+	if !p.healthy(conn) {
+		t.Error("connection is unhealthy after Finalize")
+	}
+	p.Put(conn)
+
+	conn = p.Get(nil)
+	p.Discard(conn)
+	if isOpen(conn) {
+		t.Error("discarded connection was kept")
+	}
+	if n := p.Stats().Discards; n != 2 {
+		t.Errorf("Discards = %d, want 2", n)
+	}
+	if conn := p.Get(nil); conn == nil {
+		t.Error("no replacement connection")
+	} else {
+		p.Put(conn)
+	}
+}
+
+func TestPoolFatalError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlitex-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db := filepath.Join(dir, "garbage.db")
+	if err := ioutil.WriteFile(db, bytes.Repeat([]byte("x"), 4096), 0666); err != nil {
+		t.Fatal(err)
+	}
+	p, err := OpenPool(nil, db, PoolOptions{
+		Flags:   sqlite.SQLITE_OPEN_READWRITE,
+		MinSize: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+
+	conn := p.Get(nil)
+	_, err = conn.Prepare("SELECT count(*) FROM sqlite_master;")
+	if code := conn.LastErrorCode(); code != sqlite.SQLITE_NOTADB {
+		t.Fatalf("err = %v, LastErrorCode = %v, want SQLITE_NOTADB", err, code)
+	}
+	p.Put(conn)
+	if n := p.Stats().Discards; n != 1 {
+		t.Errorf("Discards = %d, want 1", n)
+	}
+}
+
+func TestPoolShutdown(t *testing.T) {
+	Debug = true
+	defer func() { Debug = false }()
+
+	dir, err := ioutil.TempDir("", "sqlitex-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	p, err := OpenPool(nil, filepath.Join(dir, "pool.db"), PoolOptions{MinSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	held := p.Get(nil)
+	p.Put(p.Get(nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err = p.Shutdown(ctx)
+	serr, ok := err.(*ShutdownError)
+	if !ok {
+		t.Fatalf("Shutdown err = %v, want *ShutdownError", err)
+	}
+	if serr.Err != context.DeadlineExceeded || len(serr.Conns) != 1 {
+		t.Errorf("ShutdownError = %+v", serr)
+	}
+	if !strings.Contains(serr.Error(), "TestPoolShutdown") {
+		t.Errorf("ShutdownError does not include the Get stack:\n%s", serr)
+	}
+	if conn := p.Get(nil); conn != nil {
+		t.Error("Get after Shutdown returned a Conn")
+	}
+	if _, err := held.Prep("SELECT 1;").Step(); sqlite.ErrCode(err) != sqlite.SQLITE_INTERRUPT {
+		t.Errorf("held connection err = %v, want SQLITE_INTERRUPT", err)
+	}
+
+	p.Put(held)
+	if err := p.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if open, _ := p.sizes(); open != 0 {
+		t.Errorf("%d connections open after Shutdown", open)
+	}
+}
+
+func TestPoolLeak(t *testing.T) {
+	leaks := make(chan ConnInfo, 1)
+	p, cleanup := openTestPool(t, PoolOptions{
+		MinSize:       1,
+		LeakThreshold: 20 * time.Millisecond,
+		OnLeak:        func(info ConnInfo) { leaks <- info },
+	})
+	defer cleanup()
+
+	conn := p.Get(nil)
+	select {
+	case info := <-leaks:
+		if !info.Leaked || !strings.Contains(info.Stack, "TestPoolLeak") {
+			t.Errorf("leak info = %+v", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("leak not reported")
+	}
+	if n := p.Stats().Leaked; n != 1 {
+		t.Errorf("Leaked = %d, want 1", n)
+	}
+	p.Put(conn)
+	if n := p.Stats().Leaked; n != 0 {
+		t.Errorf("Leaked after Put = %d, want 0", n)
+	}
+}
+
+func TestPoolScrub(t *testing.T) {
+	var logs []string
+	p, cleanup := openTestPool(t, PoolOptions{
+		MinSize:    1,
+		InitScript: `CREATE TABLE IF NOT EXISTS t (c); CREATE TEMP VIEW v AS SELECT c FROM t;`,
+		Scrub:      true,
+		Logf: func(format string, v ...interface{}) {
+			logs = append(logs, fmt.Sprintf(format, v...))
+		},
+	})
+	defer cleanup()
+
+	conn := p.Get(nil)
+	err := ExecScript(conn, `INSERT INTO t (c) VALUES (1), (2);
+		ATTACH DATABASE ':memory:' AS aux;
+		CREATE TEMP TABLE scratch (c);
+		CREATE TEMP TRIGGER scratch_trigger AFTER INSERT ON scratch BEGIN SELECT 1; END;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow := sqlite.AuthorizeFunc(func(sqlite.ActionInfo) sqlite.AuthResult { return 0 })
+	if err := conn.SetAuthorizer(allow); err != nil {
+		t.Fatal(err)
+	}
+	if hasRow, err := conn.Prep("SELECT c FROM t;").Step(); err != nil || !hasRow {
+		t.Fatal(hasRow, err)
+	}
+	if err := ExecTransient(conn, "BEGIN;", nil); err != nil {
+		t.Fatal(err)
+	}
+	p.Put(conn)
+
+	if len(logs) != 6 {
+		t.Errorf("got %d log lines, want 6:\n%s", len(logs), strings.Join(logs, "\n"))
+	}
+	conn2 := p.Get(nil)
+	defer p.Put(conn2)
+	if conn2 != conn {
+		t.Fatal("scrubbed connection was not reused")
+	}
+	if !conn.GetAutocommit() || conn.CheckReset() != "" || conn.Authorizer() != nil {
+		t.Error("connection state not scrubbed")
+	}
+	objs, err := tempObjects(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objs) != 1 || objs[0].name != "v" {
+		t.Errorf("temp objects = %v, want only v", objs)
+	}
+	n, err := ResultInt(conn.Prep("SELECT count(*) FROM pragma_database_list;"))
+	if err != nil || n != 2 {
+		t.Errorf("%d databases, %v, want main and temp", n, err)
+	}
+}
+
+func TestPoolPriority(t *testing.T) {
+	p, cleanup := openTestPool(t, PoolOptions{MinSize: 1, MaxSize: 2, ReservedHigh: 1})
+	defer cleanup()
+	ctx := context.Background()
+
+	low := p.GetWithPriority(ctx, PriorityLow)
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	if conn := p.Get(cctx); conn != nil {
+		t.Error("PriorityNormal Get took the reserved connection")
+	}
+	cancel()
+	high := p.GetWithPriority(ctx, PriorityHigh)
+	if high == nil {
+		t.Fatal("PriorityHigh Get did not get the reserved connection")
+	}
+	p.Put(high)
+	p.Put(low)
+}
+
+func TestPoolReservedHighSize(t *testing.T) {
+	for _, opts := range []PoolOptions{
+		{MaxSize: 2, ReservedHigh: 2},
+		{MinSize: 1, ReservedHigh: 1},
+		{MaxSize: 2, ReservedHigh: -1},
+	} {
+		if p, err := OpenPool(nil, "file::memory:?mode=memory", opts); err == nil {
+			p.Close()
+			t.Errorf("OpenPool(%+v) succeeded, want error", opts)
+		}
+	}
+}
+
+func TestPoolFairQueuing(t *testing.T) {
+	p, cleanup := openTestPool(t, PoolOptions{MinSize: 1})
+	defer cleanup()
+	ctx := context.Background()
+	held := p.Get(ctx)
+
+	// With the Pool exhausted, queue four Gets of each of PriorityLow
+	// and PriorityHigh, alternating, and see who is served first.
+	served := make(chan Priority, 8)
+	queued := 0
+	for i := 0; i < 8; i++ {
+		prio := PriorityLow
+		if i%2 == 1 {
+			prio = PriorityHigh
+		}
+		go func() {
+			conn := p.GetWithPriority(ctx, prio)
+			served <- prio
+			p.Put(conn)
+		}()
+		queued++
+		for {
+			stats := p.Stats()
+			if stats.WaitingLow+stats.WaitingHigh == queued {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if stats := p.Stats(); stats.WaitingLow != 4 || stats.WaitingHigh != 4 {
+		t.Errorf("waiting: %d low, %d high, want 4, 4", stats.WaitingLow, stats.WaitingHigh)
+	}
+	p.Put(held)
+
+	var order []Priority
+	for i := 0; i < 8; i++ {
+		order = append(order, <-served)
+	}
+	highs := 0
+	for _, prio := range order[:5] {
+		if prio == PriorityHigh {
+			highs++
+		}
+	}
+	if order[0] != PriorityHigh || highs != 4 {
+		t.Errorf("served in order %v, want high first and all high in the first 5", order)
+	}
+}
+
+func TestPoolRetryKeepsOrder(t *testing.T) {
+	p, cleanup := openTestPool(t, PoolOptions{MinSize: 1, MaxSize: 1})
+	defer cleanup()
+	ctx := context.Background()
+	held := p.Get(ctx)
+
+	waiting := func(n int) {
+		for p.Stats().WaitingNormal != n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	served := make(chan string, 2)
+	for i, name := range []string{"first", "second"} {
+		name := name
+		go func() {
+			conn := p.Get(ctx)
+			served <- name
+			p.Put(conn)
+		}()
+		waiting(i + 1)
+	}
+
+	// Wake the first Get as if a slot had opened up, but take the
+	// slot away before it can retry, so it has to wait again.
+	p.mu.Lock()
+	p.opts.MaxSize++
+	p.wake(nil)
+	p.opts.MaxSize--
+	p.mu.Unlock()
+	waiting(2)
+
+	p.Put(held)
+	if first, second := <-served, <-served; first != "first" || second != "second" {
+		t.Errorf("served %s then %s, want first then second", first, second)
+	}
+}
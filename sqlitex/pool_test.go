@@ -135,6 +135,9 @@ func TestPoolAfterClose(t *testing.T) {
 			t.Fatal("dbpool: Get after Close -> !nil conn")
 		}
 	}
+	if conn, err := dbpool.Take(nil); conn != nil || err != sqlitex.ErrPoolClosed {
+		t.Errorf("Take after Close = %v, %v, want ErrPoolClosed", conn, err)
+	}
 }
 
 func TestSharedCacheLock(t *testing.T) {
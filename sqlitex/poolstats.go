@@ -0,0 +1,73 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"expvar"
+	"time"
+)
+
+// PoolStats reports on the use of a Pool.
+//
+// The counters are cumulative from when the Pool was opened. A Get
+// that finds no idle connection and cannot open one counts as a wait,
+// and the time it spends blocked is added to WaitDuration. Comparing
+// WaitDuration with query latency shows whether slow requests are
+// waiting for the Pool or for SQLite.
+type PoolStats struct {
+	Open     int // connections open, idle or in use
+	Idle     int // connections in the Pool
+	InUse    int // connections returned by Get and not yet Put
+	MaxInUse int // most connections in use at once
+	Leaked   int // connections in use for longer than LeakThreshold
+
+	WaitingLow    int // Gets of PriorityLow waiting for a connection
+	WaitingNormal int // Gets of PriorityNormal waiting for a connection
+	WaitingHigh   int // Gets of PriorityHigh waiting for a connection
+
+	Gets         int64         // calls to Get
+	Waits        int64         // Gets that had to wait for a connection
+	WaitDuration time.Duration // total time Gets spent waiting
+	Timeouts     int64         // waiting Gets whose context deadline passed
+	Cancels      int64         // waiting Gets whose context was cancelled
+	Discards     int64         // connections closed by Put or Discard
+	OpenErrors   int64         // Gets that failed to open a connection
+}
+
+// Stats reports statistics for the Pool.
+func (p *Pool) Stats() PoolStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := p.stats
+	stats.Open = len(p.all)
+	stats.Idle = len(p.free)
+	stats.WaitingLow = len(p.waiters[PriorityLow])
+	stats.WaitingNormal = len(p.waiters[PriorityNormal])
+	stats.WaitingHigh = len(p.waiters[PriorityHigh])
+	for _, st := range p.all {
+		if st.leaked && st.cancel != nil {
+			stats.Leaked++
+		}
+	}
+	return stats
+}
+
+// StatsVar returns an expvar.Var reporting the Pool's Stats as JSON,
+// for publishing with expvar.Publish:
+//
+//	expvar.Publish("dbpool", dbpool.StatsVar())
+func (p *Pool) StatsVar() expvar.Var {
+	return expvar.Func(func() interface{} { return p.Stats() })
+}
@@ -0,0 +1,184 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strconv"
+
+	"crawshaw.io/sqlite"
+)
+
+// Debug enables checks for misuse that are too expensive to leave on
+// in production. Currently, when Debug is set, Query records its
+// caller and a *Rows garbage collected without a call to Close
+// panics with that location, and Pool.Get records the stack of its
+// caller for the *ShutdownError of Pool.Shutdown.
+//
+// Set Debug before calling any other function in this package.
+var Debug = false
+
+// Rows is a cursor over the result of a query, created by Query.
+//
+// A Rows is used from the goroutine that owns its Conn.
+// Close must be called when done with a Rows,
+// typically with defer:
+//
+//	rows, err := sqlitex.Query(conn, "SELECT id, name FROM people WHERE age > ?;", 21)
+//	if err != nil {
+//		return err
+//	}
+//	defer rows.Close()
+//	for rows.Next() {
+//		var id int64
+//		var name string
+//		if err := rows.Scan(&id, &name); err != nil {
+//			return err
+//		}
+//		// ...
+//	}
+//	if err := rows.Err(); err != nil {
+//		return err
+//	}
+type Rows struct {
+	stmt   *sqlite.Stmt
+	err    error
+	hasRow bool // Next last returned true
+	done   bool
+	closed bool
+
+	structType reflect.Type // type of the last ScanStruct destination
+	plan       *structPlan
+	cols       []int
+}
+
+// Query executes an SQLite query and returns a cursor over the
+// result rows. Args are bound as by Exec.
+//
+// Like Exec, Query uses Conn.Prepare, so the statement is cached.
+// It stays in use, and so is not evicted from a bounded statement
+// cache, until the Rows is closed.
+// As the cached statement is shared, the same query string must not
+// be passed to Query, Exec, or Prepare on the Conn while a Rows for
+// it is open.
+func Query(conn *sqlite.Conn, query string, args ...interface{}) (*Rows, error) {
+	stmt, err := conn.Prepare(query)
+	if err != nil {
+		return nil, annotateErr(err)
+	}
+	for i, arg := range args {
+		if err := bindArg(stmt, i+1, arg); err != nil { // parameters are 1-indexed
+			stmt.Reset()
+			return nil, err
+		}
+	}
+	rows := &Rows{stmt: stmt}
+	if Debug {
+		_, file, line, _ := runtime.Caller(1)
+		runtime.SetFinalizer(rows, func(rows *Rows) {
+			if !rows.closed {
+				panic(file + ":" + strconv.Itoa(line) + ": *sqlitex.Rows for " + strconv.Quote(query) + " garbage collected, call Close method")
+			}
+		})
+	}
+	return rows, nil
+}
+
+// Next steps to the next result row, reporting whether there is one.
+//
+// When Next reports false, either all rows have been read or there
+// was an error, which is reported by Err.
+func (rows *Rows) Next() bool {
+	rows.hasRow = false
+	if rows.closed || rows.done || rows.err != nil {
+		return false
+	}
+	hasRow, err := rows.stmt.Step()
+	if err != nil {
+		rows.err = annotateErr(err)
+		return false
+	}
+	if !hasRow {
+		rows.done = true
+	}
+	rows.hasRow = hasRow
+	return hasRow
+}
+
+// Stmt returns the statement positioned at the current row.
+// Its Column* and Get* methods can be used to read the row.
+func (rows *Rows) Stmt() *sqlite.Stmt {
+	return rows.stmt
+}
+
+// Err returns the error, if any, encountered by Next.
+func (rows *Rows) Err() error {
+	return rows.err
+}
+
+// Close resets the underlying statement, releasing any locks it
+// holds so the Conn can be returned to a Pool.
+// Close can be called multiple times.
+func (rows *Rows) Close() error {
+	if rows.closed {
+		return nil
+	}
+	rows.closed = true
+	rows.hasRow = false
+	return rows.stmt.Reset()
+}
+
+// Scan copies the columns of the current row into dests, as by the
+// package function Scan.
+func (rows *Rows) Scan(dests ...interface{}) error {
+	if err := rows.checkRow(); err != nil {
+		return err
+	}
+	return Scan(rows.stmt, dests...)
+}
+
+// ScanStruct copies the current row into the struct pointed to by
+// dst. Columns are mapped to fields as by QueryStructs.
+func (rows *Rows) ScanStruct(dst interface{}) error {
+	if err := rows.checkRow(); err != nil {
+		return err
+	}
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("sqlitex.Rows.ScanStruct: dst is %T, not a pointer to a struct", dst)
+	}
+	v = v.Elem()
+	if v.Type() != rows.structType {
+		plan, err := planFor(v.Type())
+		if err != nil {
+			return err
+		}
+		cols, err := plan.columnFields(rows.stmt, v.Type())
+		if err != nil {
+			return err
+		}
+		rows.structType, rows.plan, rows.cols = v.Type(), plan, cols
+	}
+	return rows.plan.scan(rows.stmt, rows.cols, v)
+}
+
+func (rows *Rows) checkRow() error {
+	if !rows.hasRow {
+		return strerror{msg: "sqlitex.Rows: no current row, Next has not returned true"}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex_test
+
+import (
+	"context"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+func TestRows(t *testing.T) {
+	dbpool, err := sqlitex.Open("file::memory:?mode=memory&cache=shared", 0, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dbpool.Close()
+
+	conn := dbpool.Get(context.Background())
+	if err := sqlitex.ExecScript(conn, `CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT NOT NULL, email TEXT, created INTEGER);
+		INSERT INTO people (name, created) VALUES ('ann', 10), ('bob', 20), ('cat', 30);`); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := sqlitex.Query(conn, "SELECT id, name FROM people WHERE created > ? ORDER BY id;", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			t.Fatal(err)
+		}
+		if id != rows.Stmt().ColumnInt64(0) {
+			t.Errorf("Scan id = %d, Stmt id = %d", id, rows.Stmt().ColumnInt64(0))
+		}
+		names = append(names, name)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 2 || names[0] != "bob" || names[1] != "cat" {
+		t.Errorf("names = %q", names)
+	}
+	if err := rows.Scan(new(int64), new(string)); err == nil {
+		t.Error("Scan after Close succeeded")
+	}
+
+	// Closing part way through must leave the conn fit for Put.
+	rows, err = sqlitex.Query(conn, "SELECT * FROM people ORDER BY id;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rows.Next() {
+		t.Fatalf("no rows: %v", rows.Err())
+	}
+	var p person
+	if err := rows.ScanStruct(&p); err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != 1 || p.Name != "ann" || p.Created != 10 {
+		t.Errorf("ScanStruct = %+v", p)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Errorf("second Close: %v", err)
+	}
+	if rows.Next() {
+		t.Error("Next after Close reported a row")
+	}
+	if query := conn.CheckReset(); query != "" {
+		t.Errorf("CheckReset after Close = %q", query)
+	}
+	dbpool.Put(conn)
+
+	conn = dbpool.Get(context.Background())
+	defer dbpool.Put(conn)
+	if _, err := sqlitex.Query(conn, "SELECT * FROM nosuchtable;"); err == nil {
+		t.Error("Query of missing table succeeded")
+	}
+	rows, err = sqlitex.Query(conn, "SELECT abs(-9223372036854775807 - 1);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rows.Next() {
+		t.Error("Next reported a row for integer overflow")
+	}
+	if sqlite.ErrCode(rows.Err()) != sqlite.SQLITE_ERROR {
+		t.Errorf("Err = %v, want SQLITE_ERROR", rows.Err())
+	}
+	rows.Close()
+}
+
+func TestRowsStmtCache(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetStmtCacheCapacity(1)
+
+	rows, err := sqlitex.Query(conn, "SELECT 1;")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Scan(new(int)); err == nil {
+		t.Error("Scan before Next succeeded")
+	}
+	for rows.Next() {
+	}
+	// The open Rows keeps its statement from being evicted.
+	if err := sqlitex.Exec(conn, "SELECT 2;", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := rows.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
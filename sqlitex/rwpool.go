@@ -0,0 +1,210 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"context"
+	"sync"
+
+	"crawshaw.io/sqlite"
+)
+
+// RWPool is a pool of read-only SQLite connections with a single
+// writable connection.
+//
+// In WAL mode SQLite allows many readers alongside one writer. With a
+// Pool of identical connections, concurrent writers contend for the
+// write lock through the busy timeout. An RWPool instead queues writers
+// for its one writable connection in FIFO order, while readers proceed
+// in parallel:
+//
+//	conn := dbpool.GetWriter(ctx)
+//	if conn == nil {
+//		return context.Canceled
+//	}
+//	defer dbpool.Put(conn)
+//
+// Reader connections are opened with SQLITE_OPEN_READONLY and
+// PRAGMA query_only, so an accidental write fails rather than taking
+// the write lock.
+//
+// It is safe for use by multiple goroutines concurrently.
+type RWPool struct {
+	readers *Pool
+	writer  *Pool
+
+	mu      sync.Mutex
+	held    bool            // the writer is checked out
+	out     *sqlite.Conn    // the Conn returned by GetWriter, until Put
+	waiters []chan struct{} // goroutines queued for the writer, in order
+}
+
+// RWPoolOptions configures OpenRW.
+type RWPoolOptions struct {
+	// Flags are the open flags of the writer. A value of 0 defaults to
+	// the flags documented in Open. Readers are opened with the same
+	// flags, minus SQLITE_OPEN_READWRITE, SQLITE_OPEN_CREATE and
+	// SQLITE_OPEN_WAL, plus SQLITE_OPEN_READONLY.
+	Flags sqlite.OpenFlags
+
+	// Readers is the number of reader connections.
+	Readers int
+
+	// ReaderInitScript and WriterInitScript are run on each reader
+	// and on the writer when it is opened. See OpenInit.
+	ReaderInitScript string
+	WriterInitScript string
+}
+
+// OpenRW opens an RWPool.
+//
+// The writer is opened first, so that it can create the database and
+// put it in WAL mode before the readers open it.
+func OpenRW(ctx context.Context, uri string, opts RWPoolOptions) (pool *RWPool, err error) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	flags := opts.Flags
+	if flags == 0 {
+		flags = sqlite.SQLITE_OPEN_READWRITE |
+			sqlite.SQLITE_OPEN_CREATE |
+			sqlite.SQLITE_OPEN_WAL |
+			sqlite.SQLITE_OPEN_URI |
+			sqlite.SQLITE_OPEN_NOMUTEX
+	}
+
+	p := &RWPool{}
+	p.writer, err = OpenInit(ctx, uri, flags, 1, opts.WriterInitScript)
+	if err != nil {
+		return nil, err
+	}
+	readerFlags := flags&^(sqlite.SQLITE_OPEN_READWRITE|sqlite.SQLITE_OPEN_CREATE|sqlite.SQLITE_OPEN_WAL) | sqlite.SQLITE_OPEN_READONLY
+	readerScript := opts.ReaderInitScript + "\nPRAGMA query_only = 1;"
+	p.readers, err = OpenInit(ctx, uri, readerFlags, opts.Readers, readerScript)
+	if err != nil {
+		p.writer.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// GetReader returns a read-only connection from the pool.
+// It behaves like Pool.Get.
+func (p *RWPool) GetReader(ctx context.Context) *sqlite.Conn {
+	return p.readers.Get(ctx)
+}
+
+// GetWriter returns the writable connection.
+//
+// If the writer is in use, GetWriter waits behind any other callers
+// of GetWriter, in the order they called, until the writer is Put or
+// either the pool is closed or the context expires. If the writer
+// cannot be obtained, nil is returned.
+func (p *RWPool) GetWriter(ctx context.Context) *sqlite.Conn {
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	if !p.acquire(done) {
+		return nil
+	}
+	conn := p.writer.Get(ctx)
+	if conn == nil {
+		p.release()
+		return nil
+	}
+	// The writer Pool may have replaced its Conn since the last
+	// GetWriter, so Put recognizes the writer by this checkout.
+	p.mu.Lock()
+	p.out = conn
+	p.mu.Unlock()
+	return conn
+}
+
+// Put puts a connection from GetReader or GetWriter back into the pool.
+//
+// Put will panic if conn is nil or if conn was not created by p.
+func (p *RWPool) Put(conn *sqlite.Conn) {
+	p.mu.Lock()
+	writer := conn != nil && conn == p.out
+	if writer {
+		p.out = nil
+	}
+	p.mu.Unlock()
+	if writer {
+		p.writer.Put(conn)
+		p.release()
+		return
+	}
+	p.readers.Put(conn)
+}
+
+// Close interrupts and closes all the connections in the pool.
+// It blocks until all connections are returned, as Pool.Close does.
+func (p *RWPool) Close() error {
+	err := p.writer.Close()
+	if err2 := p.readers.Close(); err == nil {
+		err = err2
+	}
+	return err
+}
+
+// acquire waits for the writer to be free and marks it held.
+// It reports false if done or the pool is closed first.
+func (p *RWPool) acquire(done <-chan struct{}) bool {
+	p.mu.Lock()
+	if !p.held && len(p.waiters) == 0 {
+		p.held = true
+		p.mu.Unlock()
+		return true
+	}
+	ch := make(chan struct{})
+	p.waiters = append(p.waiters, ch)
+	p.mu.Unlock()
+
+	select {
+	case <-ch:
+		return true
+	case <-done:
+	case <-p.writer.closed:
+	}
+
+	p.mu.Lock()
+	for i, w := range p.waiters {
+		if w == ch {
+			p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+			p.mu.Unlock()
+			return false
+		}
+	}
+	p.mu.Unlock()
+
+	// The writer was handed to us as we gave up. Pass it on.
+	p.release()
+	return false
+}
+
+// release hands the writer to the first waiter, or marks it free.
+func (p *RWPool) release() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.waiters) == 0 {
+		p.held = false
+		return
+	}
+	ch := p.waiters[0]
+	p.waiters = p.waiters[1:]
+	close(ch)
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+func openRW(t *testing.T) (pool *RWPool, cleanup func()) {
+	dir, err := ioutil.TempDir("", "sqlitex-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool, err = OpenRW(nil, filepath.Join(dir, "rw.db"), RWPoolOptions{
+		Readers:          2,
+		WriterInitScript: `CREATE TABLE IF NOT EXISTS t (c);`,
+		ReaderInitScript: `CREATE TEMP VIEW IF NOT EXISTS v AS SELECT c FROM t;`,
+	})
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return pool, func() {
+		pool.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestRWPool(t *testing.T) {
+	pool, cleanup := openRW(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	w := pool.GetWriter(ctx)
+	if err := Exec(w, "INSERT INTO t (c) VALUES (1);", nil); err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(w)
+
+	r := pool.GetReader(ctx)
+	defer pool.Put(r)
+	if n, err := ResultInt(r.Prep("SELECT count(*) FROM v;")); err != nil || n != 1 {
+		t.Errorf("count = %d, %v, want 1", n, err)
+	}
+	err := Exec(r, "INSERT INTO t (c) VALUES (2);", nil)
+	if code := sqlite.ErrCode(err); code != sqlite.SQLITE_READONLY {
+		t.Errorf("reader INSERT err = %v, want SQLITE_READONLY", err)
+	}
+	// query_only also covers the temp database, which a read-only
+	// connection could otherwise write to.
+	if err := Exec(r, "CREATE TEMP TABLE tt (c);", nil); err == nil {
+		t.Error("reader created a temp table")
+	}
+}
+
+func TestRWPoolWriterFIFO(t *testing.T) {
+	pool, cleanup := openRW(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	w := pool.GetWriter(ctx)
+
+	// A waiter that gives up leaves the queue.
+	cctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	if conn := pool.GetWriter(cctx); conn != nil {
+		t.Fatal("GetWriter returned the writer while it was in use")
+	}
+	cancel()
+
+	const n = 4
+	order := make(chan int, n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			conn := pool.GetWriter(ctx)
+			order <- i
+			pool.Put(conn)
+		}(i)
+		// Wait for goroutine i to join the queue before starting the next.
+		for {
+			pool.mu.Lock()
+			queued := len(pool.waiters)
+			pool.mu.Unlock()
+			if queued == i+1 {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	pool.Put(w)
+
+	var got []int
+	for i := 0; i < n; i++ {
+		got = append(got, <-order)
+	}
+	if want := []int{0, 1, 2, 3}; !reflect.DeepEqual(got, want) {
+		t.Errorf("writer order = %v, want %v", got, want)
+	}
+}
+
+func TestRWPoolWriterReplaced(t *testing.T) {
+	pool, cleanup := openRW(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// A writer left in a transaction is closed by Put, and the
+	// next GetWriter opens a replacement.
+	w := pool.GetWriter(ctx)
+	if err := ExecTransient(w, "BEGIN;", nil); err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(w)
+
+	w2 := pool.GetWriter(ctx)
+	if w2 == nil {
+		t.Fatal("GetWriter returned nil after the writer was replaced")
+	}
+	if w2 == w {
+		t.Error("writer left in a transaction was reused")
+	}
+	if err := Exec(w2, "INSERT INTO t (c) VALUES (1);", nil); err != nil {
+		t.Fatal(err)
+	}
+	pool.Put(w2)
+
+	cctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	if w3 := pool.GetWriter(cctx); w3 == nil {
+		t.Error("writer not released by Put of the replacement")
+	} else {
+		pool.Put(w3)
+	}
+}
@@ -0,0 +1,342 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"database/sql"
+	"encoding"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+// ScanError reports a result row that cannot be stored in the
+// destinations passed to Scan or ScanNamed.
+type ScanError struct {
+	Col  int               // column index, or -1 if the error is not about one column
+	Name string            // column name
+	Type sqlite.ColumnType // type of the column value
+	Dest string            // Go type of the destination
+	Msg  string
+}
+
+func (err *ScanError) Error() string {
+	str := "sqlitex.Scan: "
+	if err.Col >= 0 {
+		str += "column " + strconv.Itoa(err.Col)
+		if err.Name != "" {
+			str += " (" + strconv.Quote(err.Name) + ")"
+		}
+		str += ": "
+	} else if err.Name != "" {
+		str += "column " + strconv.Quote(err.Name) + ": "
+	}
+	if err.Dest != "" {
+		str += "cannot store " + err.Type.String() + " in " + err.Dest
+		if err.Msg != "" {
+			str += ": "
+		}
+	}
+	return str + err.Msg
+}
+
+// Scan copies the columns of the current result row of stmt into
+// the values pointed at by dests, in column order.
+//
+// The number of dests must match the number of columns.
+// Supported destinations are pointers to:
+//
+//	integers     from INTEGER, integral FLOAT, or numeric TEXT
+//	floats       from INTEGER, FLOAT, or numeric TEXT
+//	string       from TEXT, INTEGER, FLOAT, or BLOB
+//	[]byte       from BLOB or TEXT; NULL is stored as nil
+//	bool         from INTEGER (non-zero is true) or TEXT parsed by strconv.ParseBool
+//	time.Time    from TEXT, INTEGER, or FLOAT, as by Stmt.ColumnTime
+//	interface{}  from any column, as int64, float64, string, []byte, or nil
+//
+// A destination that implements sqlite.Scanner or sql.Scanner reads
+// the column itself, including NULL. Otherwise, for a destination that
+// implements encoding.BinaryUnmarshaler or encoding.TextUnmarshaler, a
+// BLOB is passed to UnmarshalBinary and any other value to
+// UnmarshalText, if the destination implements both.
+//
+// Unlike the Stmt.Column* methods, Scan does not read NULL as a zero
+// value. To accept NULL, pass a pointer to a pointer; it is set to nil
+// for NULL and to a newly allocated value otherwise:
+//
+//	var name *string
+//	err := sqlitex.Scan(stmt, &id, &name)
+//
+// If a value cannot be converted, Scan returns a *ScanError.
+// Destinations before the failing column may have been modified.
+func Scan(stmt *sqlite.Stmt, dests ...interface{}) error {
+	if n := stmt.ColumnCount(); len(dests) != n {
+		return &ScanError{Col: -1, Msg: "have " + strconv.Itoa(len(dests)) + " destinations for " + strconv.Itoa(n) + " columns"}
+	}
+	for col, dest := range dests {
+		if err := scanDest(stmt, col, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ScanNamed copies columns of the current result row of stmt into
+// the values pointed at by dests, which maps column names to
+// destinations. Columns are found using Stmt.ColumnIndex.
+//
+// Columns not named in dests are ignored. A name that is not a
+// column of stmt is an error. Destinations are handled as in Scan.
+func ScanNamed(stmt *sqlite.Stmt, dests map[string]interface{}) error {
+	for name, dest := range dests {
+		col := stmt.ColumnIndex(name)
+		if col < 0 {
+			return &ScanError{Col: -1, Name: name, Msg: "no such column"}
+		}
+		if err := scanDest(stmt, col, dest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanDest(stmt *sqlite.Stmt, col int, dest interface{}) error {
+	v := reflect.ValueOf(dest)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return &ScanError{Col: col, Name: stmt.ColumnName(col), Msg: "destination is not a non-nil pointer"}
+	}
+	return scanValue(stmt, col, v.Elem())
+}
+
+var (
+	bytesType = reflect.TypeOf([]byte(nil))
+	timeType  = reflect.TypeOf(time.Time{})
+)
+
+// scanValue stores column col of the current row of stmt in v,
+// which must be settable.
+func scanValue(stmt *sqlite.Stmt, col int, v reflect.Value) error {
+	typ := stmt.ColumnType(col)
+	convErr := func(msg string) error {
+		return &ScanError{
+			Col:  col,
+			Name: stmt.ColumnName(col),
+			Type: typ,
+			Dest: v.Type().String(),
+			Msg:  msg,
+		}
+	}
+
+	if v.Kind() == reflect.Ptr {
+		if typ == sqlite.SQLITE_NULL {
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := scanValue(stmt, col, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	}
+
+	switch dest := v.Addr().Interface().(type) {
+	case sqlite.Scanner:
+		if err := dest.ScanSQLite(stmt, col); err != nil {
+			return convErr(err.Error())
+		}
+		return nil
+	case sql.Scanner:
+		if err := dest.Scan(columnValue(stmt, col)); err != nil {
+			return convErr(err.Error())
+		}
+		return nil
+	}
+
+	if v.Type() == bytesType {
+		switch typ {
+		case sqlite.SQLITE_NULL:
+			v.SetBytes(nil)
+		case sqlite.SQLITE_BLOB, sqlite.SQLITE_TEXT:
+			b := make([]byte, stmt.ColumnLen(col))
+			stmt.ColumnBytes(col, b)
+			v.SetBytes(b)
+		default:
+			return convErr("")
+		}
+		return nil
+	}
+
+	if typ == sqlite.SQLITE_NULL && v.Kind() != reflect.Interface {
+		return convErr("use a pointer destination to accept NULL")
+	}
+
+	if v.Type() == timeType {
+		t, err := stmt.ColumnTime(col)
+		if err != nil {
+			if err, ok := err.(sqlite.Error); ok {
+				return convErr(err.Msg)
+			}
+			return convErr(err.Error())
+		}
+		v.Set(reflect.ValueOf(t))
+		return nil
+	}
+
+	textU, isText := v.Addr().Interface().(encoding.TextUnmarshaler)
+	binaryU, isBinary := v.Addr().Interface().(encoding.BinaryUnmarshaler)
+	if isText || isBinary {
+		var err error
+		b := make([]byte, stmt.ColumnLen(col))
+		stmt.ColumnBytes(col, b)
+		if isBinary && (typ == sqlite.SQLITE_BLOB || !isText) {
+			err = binaryU.UnmarshalBinary(b)
+		} else {
+			err = textU.UnmarshalText(b)
+		}
+		if err != nil {
+			return convErr(err.Error())
+		}
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		var i int64
+		switch typ {
+		case sqlite.SQLITE_INTEGER:
+			i = stmt.ColumnInt64(col)
+		case sqlite.SQLITE_FLOAT:
+			f := stmt.ColumnFloat(col)
+			if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+				return convErr("value " + strconv.FormatFloat(f, 'g', -1, 64) + " is not an integer")
+			}
+			i = int64(f)
+		case sqlite.SQLITE_TEXT:
+			var err error
+			i, err = strconv.ParseInt(strings.TrimSpace(stmt.ColumnText(col)), 10, 64)
+			if err != nil {
+				return convErr(err.Error())
+			}
+		default:
+			return convErr("")
+		}
+		if v.OverflowInt(i) {
+			return convErr("value " + strconv.FormatInt(i, 10) + " overflows")
+		}
+		v.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		var u uint64
+		switch typ {
+		case sqlite.SQLITE_INTEGER:
+			i := stmt.ColumnInt64(col)
+			if i < 0 {
+				return convErr("value " + strconv.FormatInt(i, 10) + " is negative")
+			}
+			u = uint64(i)
+		case sqlite.SQLITE_FLOAT:
+			f := stmt.ColumnFloat(col)
+			if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+				return convErr("value " + strconv.FormatFloat(f, 'g', -1, 64) + " is not an unsigned integer")
+			}
+			u = uint64(f)
+		case sqlite.SQLITE_TEXT:
+			var err error
+			u, err = strconv.ParseUint(strings.TrimSpace(stmt.ColumnText(col)), 10, 64)
+			if err != nil {
+				return convErr(err.Error())
+			}
+		default:
+			return convErr("")
+		}
+		if v.OverflowUint(u) {
+			return convErr("value " + strconv.FormatUint(u, 10) + " overflows")
+		}
+		v.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		var f float64
+		switch typ {
+		case sqlite.SQLITE_INTEGER, sqlite.SQLITE_FLOAT:
+			f = stmt.ColumnFloat(col)
+		case sqlite.SQLITE_TEXT:
+			var err error
+			f, err = strconv.ParseFloat(strings.TrimSpace(stmt.ColumnText(col)), 64)
+			if err != nil {
+				return convErr(err.Error())
+			}
+		default:
+			return convErr("")
+		}
+		v.SetFloat(f)
+	case reflect.String:
+		switch typ {
+		case sqlite.SQLITE_BLOB:
+			b := make([]byte, stmt.ColumnLen(col))
+			stmt.ColumnBytes(col, b)
+			v.SetString(string(b))
+		default:
+			v.SetString(stmt.ColumnText(col))
+		}
+	case reflect.Bool:
+		switch typ {
+		case sqlite.SQLITE_INTEGER:
+			v.SetBool(stmt.ColumnInt64(col) != 0)
+		case sqlite.SQLITE_FLOAT:
+			v.SetBool(stmt.ColumnFloat(col) != 0)
+		case sqlite.SQLITE_TEXT:
+			b, err := strconv.ParseBool(strings.TrimSpace(stmt.ColumnText(col)))
+			if err != nil {
+				return convErr(err.Error())
+			}
+			v.SetBool(b)
+		default:
+			return convErr("")
+		}
+	case reflect.Interface:
+		if v.NumMethod() != 0 {
+			return convErr("unsupported destination type")
+		}
+		if val := columnValue(stmt, col); val == nil {
+			v.Set(reflect.Zero(v.Type()))
+		} else {
+			v.Set(reflect.ValueOf(val))
+		}
+	default:
+		return convErr("unsupported destination type")
+	}
+	return nil
+}
+
+// columnValue returns column col of the current row of stmt as an
+// int64, float64, string, []byte, or nil.
+func columnValue(stmt *sqlite.Stmt, col int) interface{} {
+	switch stmt.ColumnType(col) {
+	case sqlite.SQLITE_INTEGER:
+		return stmt.ColumnInt64(col)
+	case sqlite.SQLITE_FLOAT:
+		return stmt.ColumnFloat(col)
+	case sqlite.SQLITE_TEXT:
+		return stmt.ColumnText(col)
+	case sqlite.SQLITE_BLOB:
+		b := make([]byte, stmt.ColumnLen(col))
+		stmt.ColumnBytes(col, b)
+		return b
+	}
+	return nil
+}
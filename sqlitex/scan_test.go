@@ -0,0 +1,175 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+func TestScan(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var (
+		i     int64
+		s     string
+		b     []byte
+		f     float64
+		ok    bool
+		tm    time.Time
+		null  *string
+		ptr   *int
+		iface interface{}
+	)
+	fn := func(stmt *sqlite.Stmt) error {
+		return sqlitex.Scan(stmt, &i, &s, &b, &f, &ok, &tm, &null, &ptr, &iface)
+	}
+	err = sqlitex.Exec(conn, `SELECT 42, 'text', x'0102', 1.5, 1, '2021-03-04 05:06:07', NULL, 7, 'any';`, fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if i != 42 || s != "text" || !reflect.DeepEqual(b, []byte{1, 2}) || f != 1.5 || !ok {
+		t.Errorf("Scan got %v %q %v %v %v", i, s, b, f, ok)
+	}
+	if want := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC); !tm.Equal(want) {
+		t.Errorf("time = %v, want %v", tm, want)
+	}
+	if null != nil {
+		t.Errorf("NULL scanned into %q, want nil", *null)
+	}
+	if ptr == nil || *ptr != 7 {
+		t.Errorf("ptr = %v, want 7", ptr)
+	}
+	if iface != "any" {
+		t.Errorf("interface{} = %v, want \"any\"", iface)
+	}
+
+	tests := []struct {
+		name  string
+		query string
+		dest  interface{}
+		want  interface{}
+	}{
+		{"int from text", "SELECT ' 12 ';", new(int), 12},
+		{"int from float", "SELECT 3.0;", new(int32), int32(3)},
+		{"uint", "SELECT 255;", new(uint8), uint8(255)},
+		{"float from int", "SELECT 3;", new(float64), 3.0},
+		{"string from int", "SELECT 3;", new(string), "3"},
+		{"bool from text", "SELECT 'true';", new(bool), true},
+		{"bytes from null", "SELECT NULL;", new([]byte), []byte(nil)},
+		{"time from unix", "SELECT 86400;", new(time.Time), time.Unix(86400, 0).UTC()},
+		{"time from julianday", "SELECT julianday('2000-01-01 12:00:00');", new(time.Time), time.Date(2000, 1, 1, 12, 0, 0, 0, time.UTC)},
+		{"time from rfc3339", "SELECT '2000-01-01T12:00:00.5+01:00';", new(time.Time), time.Date(2000, 1, 1, 11, 0, 0, 5e8, time.UTC)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := func(stmt *sqlite.Stmt) error {
+				return sqlitex.Scan(stmt, test.dest)
+			}
+			if err := sqlitex.Exec(conn, test.query, fn); err != nil {
+				t.Fatal(err)
+			}
+			got := reflect.ValueOf(test.dest).Elem().Interface()
+			if gt, ok := got.(time.Time); ok {
+				if !gt.Equal(test.want.(time.Time)) {
+					t.Errorf("got %v, want %v", gt, test.want)
+				}
+				return
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("got %#v, want %#v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScanErrors(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	tests := []struct {
+		name  string
+		query string
+		dests []interface{}
+		col   int
+	}{
+		{"count", "SELECT 1, 2;", []interface{}{new(int)}, -1},
+		{"null", "SELECT 1, NULL AS n;", []interface{}{new(int), new(int)}, 1},
+		{"overflow", "SELECT 300;", []interface{}{new(int8)}, 0},
+		{"negative uint", "SELECT -1;", []interface{}{new(uint)}, 0},
+		{"fraction", "SELECT 1.5;", []interface{}{new(int)}, 0},
+		{"bad text", "SELECT 'abc';", []interface{}{new(float64)}, 0},
+		{"bad time", "SELECT 'yesterday';", []interface{}{new(time.Time)}, 0},
+		{"not a pointer", "SELECT 1;", []interface{}{0}, 0},
+		{"unsupported", "SELECT 1;", []interface{}{new(struct{})}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fn := func(stmt *sqlite.Stmt) error {
+				return sqlitex.Scan(stmt, test.dests...)
+			}
+			err := sqlitex.Exec(conn, test.query, fn)
+			scanErr, ok := err.(*sqlitex.ScanError)
+			if !ok {
+				t.Fatalf("err = %v (%T), want *sqlitex.ScanError", err, err)
+			}
+			if scanErr.Col != test.col {
+				t.Errorf("ScanError.Col = %d, want %d (%v)", scanErr.Col, test.col, err)
+			}
+		})
+	}
+}
+
+func TestScanNamed(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var a int
+	var b *string
+	fn := func(stmt *sqlite.Stmt) error {
+		return sqlitex.ScanNamed(stmt, map[string]interface{}{
+			"a": &a,
+			"b": &b,
+		})
+	}
+	if err := sqlitex.Exec(conn, "SELECT 'ignored' AS c, 'bee' AS b, 5 AS a;", fn); err != nil {
+		t.Fatal(err)
+	}
+	if a != 5 || b == nil || *b != "bee" {
+		t.Errorf("a=%d, b=%v", a, b)
+	}
+
+	fn = func(stmt *sqlite.Stmt) error {
+		return sqlitex.ScanNamed(stmt, map[string]interface{}{"missing": &a})
+	}
+	err = sqlitex.Exec(conn, "SELECT 1 AS a;", fn)
+	if scanErr, ok := err.(*sqlitex.ScanError); !ok || scanErr.Name != "missing" {
+		t.Errorf("err = %v, want ScanError for missing column", err)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"sort"
+	"strings"
+
+	"crawshaw.io/sqlite"
+)
+
+// tempObject is a table, view, trigger or index in the temp schema.
+type tempObject struct {
+	typ  string
+	name string
+}
+
+// tempObjects lists the objects in conn's temp schema, other than
+// those SQLite creates internally.
+func tempObjects(conn *sqlite.Conn) ([]tempObject, error) {
+	var objs []tempObject
+	query := `SELECT type, name FROM temp.sqlite_master WHERE name NOT LIKE 'sqlite\_%' ESCAPE '\';`
+	err := ExecTransient(conn, query, func(stmt *sqlite.Stmt) error {
+		objs = append(objs, tempObject{typ: stmt.ColumnText(0), name: stmt.ColumnText(1)})
+		return nil
+	})
+	return objs, err
+}
+
+// dropOrder is the order in which new temp objects are dropped,
+// so that dependent objects go first.
+var dropOrder = map[string]int{"trigger": 0, "view": 1, "index": 2, "table": 3}
+
+// scrub undoes the changes a caller of Get made to the state of conn.
+// It reports false if conn could not be cleaned.
+func (p *Pool) scrub(conn *sqlite.Conn, st *connState) bool {
+	logf := p.logf
+	fail := func(err error) bool {
+		logf("sqlitex: Pool.Put: scrub failed, closing connection: %v", err)
+		return false
+	}
+
+	if query := conn.CheckReset(); query != "" {
+		logf("sqlitex: Pool.Put: reset active statement %q", query)
+	}
+	// Reset all active statements, and keep the Get context
+	// from interrupting the cleanup.
+	conn.SetInterrupt(nil)
+
+	if !conn.GetAutocommit() {
+		logf("sqlitex: Pool.Put: rolled back open transaction")
+		if err := ExecTransient(conn, "ROLLBACK;", nil); err != nil {
+			return fail(err)
+		}
+	}
+
+	var attached []string
+	err := ExecTransient(conn, "PRAGMA database_list;", func(stmt *sqlite.Stmt) error {
+		if name := stmt.ColumnText(1); name != "main" && name != "temp" {
+			attached = append(attached, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return fail(err)
+	}
+	for _, name := range attached {
+		logf("sqlitex: Pool.Put: detached database %q", name)
+		if err := ExecTransient(conn, "DETACH DATABASE "+quoteIdent(name)+";", nil); err != nil {
+			return fail(err)
+		}
+	}
+
+	objs, err := tempObjects(conn)
+	if err != nil {
+		return fail(err)
+	}
+	known := make(map[tempObject]bool)
+	for _, obj := range st.temps {
+		known[obj] = true
+	}
+	var drop []tempObject
+	for _, obj := range objs {
+		if !known[obj] {
+			drop = append(drop, obj)
+		}
+	}
+	sort.SliceStable(drop, func(i, j int) bool { return dropOrder[drop[i].typ] < dropOrder[drop[j].typ] })
+	for _, obj := range drop {
+		logf("sqlitex: Pool.Put: dropped temp %s %q", obj.typ, obj.name)
+		query := "DROP " + strings.ToUpper(obj.typ) + " IF EXISTS temp." + quoteIdent(obj.name) + ";"
+		if err := ExecTransient(conn, query, nil); err != nil {
+			return fail(err)
+		}
+	}
+
+	if conn.Authorizer() != nil {
+		logf("sqlitex: Pool.Put: cleared authorizer")
+		if err := conn.SetAuthorizer(nil); err != nil {
+			return fail(err)
+		}
+	}
+	return true
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+// StatsTracer aggregates statement profiles by query fingerprint and
+// logs slow queries, in the manner of PostgreSQL's pg_stat_statements.
+//
+// A StatsTracer is attached to each connection it watches, and is
+// safe to share between connections. For a Pool, attach it in
+// PoolOptions.OnOpen:
+//
+//	tracer := &sqlitex.StatsTracer{SlowThreshold: 100 * time.Millisecond}
+//	dbpool, err := sqlitex.OpenPool(ctx, uri, sqlitex.PoolOptions{
+//		MaxSize: 10,
+//		OnOpen:  tracer.Attach,
+//	})
+type StatsTracer struct {
+	// SlowThreshold, if positive, is the duration at or above which
+	// a statement is reported to Logf.
+	SlowThreshold time.Duration
+
+	// Logf reports slow statements. If nil, log.Printf is used.
+	Logf func(format string, v ...interface{})
+
+	mu    sync.Mutex
+	stats map[string]*QueryStats
+}
+
+// QueryStats are the aggregated profiles of the statements with
+// one fingerprint. See sqlite.StmtProfile.
+type QueryStats struct {
+	Fingerprint   string
+	Calls         int64
+	TotalTime     time.Duration
+	MaxTime       time.Duration
+	Rows          int64
+	FullScanSteps int64
+	Sorts         int64
+	AutoIndexes   int64
+}
+
+// Attach makes t record the statements run on conn.
+// It replaces any profiler set with conn.SetProfiler.
+func (t *StatsTracer) Attach(conn *sqlite.Conn) error {
+	return conn.SetProfiler(t.record)
+}
+
+func (t *StatsTracer) record(p sqlite.StmtProfile) {
+	fp := Fingerprint(p.Query)
+
+	t.mu.Lock()
+	if t.stats == nil {
+		t.stats = make(map[string]*QueryStats)
+	}
+	s := t.stats[fp]
+	if s == nil {
+		s = &QueryStats{Fingerprint: fp}
+		t.stats[fp] = s
+	}
+	s.Calls++
+	s.TotalTime += p.Duration
+	if p.Duration > s.MaxTime {
+		s.MaxTime = p.Duration
+	}
+	s.Rows += p.Rows
+	s.FullScanSteps += p.FullScanSteps
+	s.Sorts += p.Sorts
+	s.AutoIndexes += p.AutoIndexes
+	t.mu.Unlock()
+
+	if t.SlowThreshold > 0 && p.Duration >= t.SlowThreshold {
+		logf := t.Logf
+		if logf == nil {
+			logf = log.Printf
+		}
+		logf("sqlitex: slow query: %v, %d rows, %d full scan steps: %s", p.Duration, p.Rows, p.FullScanSteps, p.Query)
+	}
+}
+
+// Stats reports the statistics gathered so far, by descending
+// total time.
+func (t *StatsTracer) Stats() []QueryStats {
+	t.mu.Lock()
+	stats := make([]QueryStats, 0, len(t.stats))
+	for _, s := range t.stats {
+		stats = append(stats, *s)
+	}
+	t.mu.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].TotalTime != stats[j].TotalTime {
+			return stats[i].TotalTime > stats[j].TotalTime
+		}
+		return stats[i].Fingerprint < stats[j].Fingerprint
+	})
+	return stats
+}
+
+// Reset discards the statistics gathered so far.
+func (t *StatsTracer) Reset() {
+	t.mu.Lock()
+	t.stats = nil
+	t.mu.Unlock()
+}
+
+// WriteTable replaces table with the current Stats, so they can be
+// queried with SQL. The package does not support virtual tables, so
+// the table is a snapshot. A temp table, such as "temp.stmt_stats",
+// keeps the snapshot out of the database file.
+//
+// The table has the columns fingerprint, calls, total_ns, max_ns,
+// rows, full_scan_steps, sorts and auto_indexes.
+func (t *StatsTracer) WriteTable(conn *sqlite.Conn, table string) (err error) {
+	stats := t.Stats()
+
+	defer Save(conn)(&err)
+	name := quoteTable(table)
+	err = ExecScript(conn, "DROP TABLE IF EXISTS "+name+";\n"+
+		"CREATE TABLE "+name+` (
+			fingerprint     TEXT PRIMARY KEY,
+			calls           INTEGER,
+			total_ns        INTEGER,
+			max_ns          INTEGER,
+			rows            INTEGER,
+			full_scan_steps INTEGER,
+			sorts           INTEGER,
+			auto_indexes    INTEGER
+		);`)
+	if err != nil {
+		return err
+	}
+	query := "INSERT INTO " + name + " VALUES (?, ?, ?, ?, ?, ?, ?, ?);"
+	for _, s := range stats {
+		err := Exec(conn, query, nil, s.Fingerprint, s.Calls, int64(s.TotalTime), int64(s.MaxTime),
+			s.Rows, s.FullScanSteps, s.Sorts, s.AutoIndexes)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Fingerprint normalizes an SQL query so that queries differing only
+// in literal values, parameter names, comments or white space have
+// the same fingerprint. Literals and parameters become ?, and lists
+// of them, as in IN (1, 2, 3), become a single ?.
+func Fingerprint(query string) string {
+	var b strings.Builder
+	space := false // white space is pending
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f':
+			space = true
+			i++
+			continue
+		case strings.HasPrefix(query[i:], "--"):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+			space = true
+			continue
+		case strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end >= 0 {
+				i += 2 + end + 2
+			} else {
+				i = len(query)
+			}
+			space = true
+			continue
+		case c == ',' || c == ')' || c == ';':
+			space = false
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+
+		switch {
+		case c == '\'' || ((c == 'x' || c == 'X') && i+1 < len(query) && query[i+1] == '\''):
+			if c != '\'' {
+				i++
+			}
+			i = skipQuoted(query, i, '\'')
+			b.WriteByte('?')
+		case c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := skipQuoted(query, i, end)
+			b.WriteString(query[i:j])
+			i = j
+		case isDigit(c) || (c == '.' && i+1 < len(query) && isDigit(query[i+1])):
+			for i < len(query) && (isIdentChar(query[i]) || query[i] == '.' ||
+				((query[i] == '+' || query[i] == '-') && (query[i-1] == 'e' || query[i-1] == 'E'))) {
+				i++
+			}
+			b.WriteByte('?')
+		case c == '?' || ((c == ':' || c == '@' || c == '$') && i+1 < len(query) && isIdentChar(query[i+1])):
+			i++
+			for i < len(query) && isIdentChar(query[i]) {
+				i++
+			}
+			b.WriteByte('?')
+		case isIdentChar(c):
+			j := i
+			for j < len(query) && isIdentChar(query[j]) {
+				j++
+			}
+			b.WriteString(query[i:j])
+			i = j
+		case c == ',':
+			b.WriteByte(',')
+			space = true
+			i++
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+
+	fp := strings.TrimRight(b.String(), "; ")
+	for strings.Contains(fp, "?, ?") {
+		fp = strings.Replace(fp, "?, ?", "?", -1)
+	}
+	return fp
+}
+
+// skipQuoted returns the index after the quoted text starting at
+// query[i], where doubled end quotes are escapes.
+func skipQuoted(query string, i int, end byte) int {
+	for i++; i < len(query); i++ {
+		if query[i] == end {
+			if i+1 < len(query) && query[i+1] == end && end != ']' {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(query)
+}
+
+func isDigit(c byte) bool { return '0' <= c && c <= '9' }
+
+func isIdentChar(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c == '$' || c >= 0x80
+}
@@ -0,0 +1,97 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+func TestFingerprint(t *testing.T) {
+	tests := []struct{ query, want string }{
+		{"SELECT * FROM t WHERE id = 42;", "SELECT * FROM t WHERE id = ?"},
+		{"select *\n  from t -- comment\n where id=$id", "select * from t where id=?"},
+		{"INSERT INTO t (a, b) VALUES ('it''s', x'00ff'), (1.5e-3, -7)", "INSERT INTO t (a, b) VALUES (?), (?, -?)"},
+		{"SELECT c1 FROM \"t 2\" WHERE c1 IN (1,2, 3, :x, ?4) /* done */", "SELECT c1 FROM \"t 2\" WHERE c1 IN (?)"},
+	}
+	for _, test := range tests {
+		if got := sqlitex.Fingerprint(test.query); got != test.want {
+			t.Errorf("Fingerprint(%q) = %q, want %q", test.query, got, test.want)
+		}
+	}
+}
+
+func TestStatsTracer(t *testing.T) {
+	var logs []string
+	tracer := &sqlitex.StatsTracer{
+		SlowThreshold: time.Nanosecond,
+		Logf: func(format string, v ...interface{}) {
+			logs = append(logs, format)
+		},
+	}
+	c, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if err := sqlitex.ExecScript(c, "CREATE TABLE t (c);"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracer.Attach(c); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := sqlitex.Exec(c, "INSERT INTO t (c) VALUES (?);", nil, i); err != nil {
+			t.Fatal(err)
+		}
+		if err := sqlitex.ExecTransient(c, "SELECT * FROM t WHERE c >= 0;", nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stats := make(map[string]sqlitex.QueryStats)
+	for _, s := range tracer.Stats() {
+		stats[s.Fingerprint] = s
+	}
+	if s := stats["INSERT INTO t (c) VALUES (?)"]; s.Calls != 3 || s.Rows != 0 {
+		t.Errorf("INSERT stats = %+v", s)
+	}
+	s := stats["SELECT * FROM t WHERE c >= ?"]
+	if s.Calls != 3 || s.Rows != 6 || s.FullScanSteps != 3 || s.TotalTime <= 0 || s.MaxTime > s.TotalTime {
+		t.Errorf("SELECT stats = %+v", s)
+	}
+	if len(logs) != 6 {
+		t.Errorf("logged %d slow queries, want 6", len(logs))
+	}
+
+	if err := tracer.WriteTable(c, "temp.stmt_stats"); err != nil {
+		t.Fatal(err)
+	}
+	tracer.Reset()
+	calls, err := sqlitex.ResultInt(c.Prep("SELECT calls FROM temp.stmt_stats WHERE fingerprint LIKE 'SELECT%';"))
+	if err != nil || calls != 3 {
+		t.Errorf("stmt_stats calls = %d, %v", calls, err)
+	}
+	for _, s := range tracer.Stats() {
+		if strings.HasPrefix(s.Fingerprint, "INSERT INTO t") {
+			t.Errorf("stats not reset: %+v", s)
+		}
+	}
+}
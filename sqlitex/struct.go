@@ -0,0 +1,378 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"crawshaw.io/sqlite"
+)
+
+// structField is a mapped struct field.
+type structField struct {
+	name      string // column name
+	index     []int  // for fieldByIndex
+	omitEmpty bool
+	tagged    bool // name is from a sqlite tag
+}
+
+// structPlan is the column mapping of a struct type.
+type structPlan struct {
+	fields []structField
+	byName map[string]int // lower-case column name -> fields index
+}
+
+var structPlans sync.Map // reflect.Type -> *structPlan
+
+func planFor(t reflect.Type) (*structPlan, error) {
+	if plan, ok := structPlans.Load(t); ok {
+		return plan.(*structPlan), nil
+	}
+	var fields []structField
+	if err := addFields(&fields, t, nil, map[reflect.Type]bool{t: true}); err != nil {
+		return nil, err
+	}
+
+	// A name used by several fields goes to the shallowest one, as
+	// with Go's selectors. Of fields at the same depth, a tagged one
+	// wins, and if that leaves more than one, none of them are mapped.
+	byName := make(map[string][]int)
+	for i, f := range fields {
+		key := strings.ToLower(f.name)
+		byName[key] = append(byName[key], i)
+	}
+	plan := &structPlan{byName: make(map[string]int)}
+	for i, f := range fields {
+		key := strings.ToLower(f.name)
+		if dominant(fields, byName[key]) != i {
+			continue
+		}
+		plan.byName[key] = len(plan.fields)
+		plan.fields = append(plan.fields, f)
+	}
+	actual, _ := structPlans.LoadOrStore(t, plan)
+	return actual.(*structPlan), nil
+}
+
+// dominant reports which of the fields named by same is mapped to
+// their shared column name, or -1 if the name is ambiguous.
+func dominant(fields []structField, same []int) int {
+	depth := len(fields[same[0]].index)
+	for _, i := range same[1:] {
+		if d := len(fields[i].index); d < depth {
+			depth = d
+		}
+	}
+	win, n, tagged := -1, 0, 0
+	for _, i := range same {
+		f := fields[i]
+		if len(f.index) != depth {
+			continue
+		}
+		n++
+		if f.tagged {
+			tagged++
+			win = i
+		} else if tagged == 0 {
+			win = i
+		}
+	}
+	if n == 1 || tagged == 1 {
+		return win
+	}
+	return -1
+}
+
+// addFields appends the fields of struct type t to fields, with the
+// fields of embedded structs and pointers to structs in place of the
+// embedded field. Types in visiting are not entered again, so a type
+// that embeds a pointer to itself terminates.
+func addFields(fields *[]structField, t reflect.Type, index []int, visiting map[reflect.Type]bool) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("sqlite")
+		if tag == "-" {
+			continue
+		}
+		if f.Anonymous && tag == "" {
+			et := f.Type
+			if et.Kind() == reflect.Ptr && f.PkgPath == "" {
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct && et != timeType {
+				if !visiting[et] {
+					visiting[et] = true
+					err := addFields(fields, et, append(index[:len(index):len(index)], i), visiting)
+					delete(visiting, et)
+					if err != nil {
+						return err
+					}
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue // unexported
+		}
+		name, opts := tag, ""
+		if i := strings.IndexByte(tag, ','); i >= 0 {
+			name, opts = tag[:i], tag[i+1:]
+		}
+		sf := structField{
+			name:   name,
+			index:  append(index[:len(index):len(index)], i),
+			tagged: name != "",
+		}
+		if name == "" {
+			sf.name = f.Name
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			switch opt {
+			case "":
+			case "omitempty":
+				sf.omitEmpty = true
+			default:
+				return fmt.Errorf("sqlitex: %s.%s: unknown sqlite tag option %q", t, f.Name, opt)
+			}
+		}
+		*fields = append(*fields, sf)
+	}
+	return nil
+}
+
+// fieldByIndex is reflect.Value.FieldByIndex for a field that may be
+// reached through embedded pointers. If alloc is set, nil pointers
+// are allocated. Otherwise ok is false if a pointer on the way is nil.
+func fieldByIndex(v reflect.Value, index []int, alloc bool) (fv reflect.Value, ok bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Ptr {
+			if v.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// columnFields maps each result column of stmt to a field index
+// in plan.
+func (plan *structPlan) columnFields(stmt *sqlite.Stmt, t reflect.Type) ([]int, error) {
+	cols := make([]int, stmt.ColumnCount())
+	for col := range cols {
+		name := stmt.ColumnName(col)
+		i, ok := plan.byName[strings.ToLower(name)]
+		if !ok {
+			return nil, &ScanError{Col: col, Name: name, Msg: "no field for column in " + t.String()}
+		}
+		cols[col] = i
+	}
+	return cols, nil
+}
+
+func (plan *structPlan) scan(stmt *sqlite.Stmt, cols []int, v reflect.Value) error {
+	for col, i := range cols {
+		fv, _ := fieldByIndex(v, plan.fields[i].index, true)
+		if err := scanValue(stmt, col, fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// QueryStructs executes query and appends a struct to the slice
+// pointed to by dst for each result row. The element type of the
+// slice may be a struct or a pointer to a struct.
+//
+// The column name of a field is given by its sqlite struct tag,
+// or is the field name if there is no tag. Columns are matched to
+// fields without regard to case, as SQLite identifiers are
+// case-insensitive. Every result column must match a field.
+//
+//	type Person struct {
+//		ID      int64   `sqlite:"id,omitempty"`
+//		Name    string  `sqlite:"name"`
+//		Email   *string `sqlite:"email"` // nullable
+//		Scratch string  `sqlite:"-"`     // not mapped
+//	}
+//
+//	var people []Person
+//	err := sqlitex.QueryStructs(conn, &people, "SELECT id, name, email FROM people;")
+//
+// Unexported fields and fields tagged "-" are ignored. The fields of
+// embedded structs and pointers to structs are mapped as if they were
+// fields of the outer struct, and a nil embedded pointer is allocated
+// when one of its fields is read. If several fields have the same
+// column name, the least nested one is mapped, following Go's rules
+// for selectors; of fields equally nested, a tagged one is preferred,
+// and if that does not settle it none of them is mapped.
+// Fields are read with the conversion rules of Scan.
+// The mapping of each struct type is computed once and cached.
+//
+// Args are bound as by Exec, and like Exec the statement is prepared
+// with Conn.Prepare so it is cached.
+func QueryStructs(conn *sqlite.Conn, dst interface{}, query string, args ...interface{}) error {
+	sv := reflect.ValueOf(dst)
+	if sv.Kind() != reflect.Ptr || sv.IsNil() || sv.Elem().Kind() != reflect.Slice {
+		return fmt.Errorf("sqlitex.QueryStructs: dst is %T, not a pointer to a slice", dst)
+	}
+	sv = sv.Elem()
+	elemType := sv.Type().Elem()
+	structType, isPtr := elemType, false
+	if structType.Kind() == reflect.Ptr {
+		structType, isPtr = structType.Elem(), true
+	}
+	if structType.Kind() != reflect.Struct {
+		return fmt.Errorf("sqlitex.QueryStructs: dst is %T, not a pointer to a slice of structs", dst)
+	}
+	plan, err := planFor(structType)
+	if err != nil {
+		return err
+	}
+
+	var cols []int
+	fn := func(stmt *sqlite.Stmt) error {
+		if cols == nil {
+			var err error
+			if cols, err = plan.columnFields(stmt, structType); err != nil {
+				return err
+			}
+		}
+		elem := reflect.New(structType)
+		if err := plan.scan(stmt, cols, elem.Elem()); err != nil {
+			return err
+		}
+		if !isPtr {
+			elem = elem.Elem()
+		}
+		sv.Set(reflect.Append(sv, elem))
+		return nil
+	}
+	return Exec(conn, query, fn, args...)
+}
+
+// QueryStruct executes query and stores its single result row in
+// the struct pointed to by dst. Columns are mapped to fields as by
+// QueryStructs.
+//
+// If there are no rows in the result set, ErrNoResults is returned.
+// If there are multiple rows, ErrMultipleResults is returned and dst
+// holds the first row.
+func QueryStruct(conn *sqlite.Conn, dst interface{}, query string, args ...interface{}) error {
+	v := reflect.ValueOf(dst)
+	if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("sqlitex.QueryStruct: dst is %T, not a pointer to a struct", dst)
+	}
+	v = v.Elem()
+	plan, err := planFor(v.Type())
+	if err != nil {
+		return err
+	}
+
+	rows := 0
+	fn := func(stmt *sqlite.Stmt) error {
+		rows++
+		if rows > 1 {
+			return ErrMultipleResults
+		}
+		cols, err := plan.columnFields(stmt, v.Type())
+		if err != nil {
+			return err
+		}
+		return plan.scan(stmt, cols, v)
+	}
+	if err := Exec(conn, query, fn, args...); err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoResults
+	}
+	return nil
+}
+
+// InsertStruct inserts the struct v, or the struct v points to, as a
+// new row of table. Fields are mapped to columns as by QueryStructs
+// and bound as by Exec.
+//
+// Each mapped field is a column of the INSERT, except for zero-valued
+// fields with the omitempty tag option and fields of a nil embedded
+// pointer. Omitted columns get their
+// default value, which for an INTEGER PRIMARY KEY is a new rowid.
+//
+// The table name may be qualified with a schema name, as in
+// "temp.t". The generated statement is prepared with Conn.Prepare,
+// so repeated inserts of the same type into the same table reuse a
+// cached statement.
+func InsertStruct(conn *sqlite.Conn, table string, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("sqlitex.InsertStruct: v is %T, not a struct", v)
+	}
+	plan, err := planFor(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	var cols, params strings.Builder
+	var args []interface{}
+	for _, f := range plan.fields {
+		fv, ok := fieldByIndex(rv, f.index, false)
+		if !ok || f.omitEmpty && isZero(fv) {
+			continue
+		}
+		if len(args) > 0 {
+			cols.WriteString(", ")
+			params.WriteString(", ")
+		}
+		cols.WriteString(quoteIdent(f.name))
+		params.WriteString("?")
+		args = append(args, fv.Interface())
+	}
+	var query string
+	if len(args) == 0 {
+		query = "INSERT INTO " + quoteTable(table) + " DEFAULT VALUES;"
+	} else {
+		query = "INSERT INTO " + quoteTable(table) + " (" + cols.String() + ") VALUES (" + params.String() + ");"
+	}
+	return Exec(conn, query, nil, args...)
+}
+
+func isZero(v reflect.Value) bool {
+	return reflect.DeepEqual(v.Interface(), reflect.Zero(v.Type()).Interface())
+}
+
+// quoteIdent quotes an SQL identifier.
+func quoteIdent(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// quoteTable quotes a table name, which may be qualified by a
+// schema name.
+func quoteTable(table string) string {
+	if i := strings.IndexByte(table, '.'); i >= 0 {
+		return quoteIdent(table[:i]) + "." + quoteIdent(table[i+1:])
+	}
+	return quoteIdent(table)
+}
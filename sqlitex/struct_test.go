@@ -0,0 +1,185 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex_test
+
+import (
+	"reflect"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+type audit struct {
+	Created int64 `sqlite:"created"`
+}
+
+type person struct {
+	ID      int64   `sqlite:"id,omitempty"`
+	Name    string  `sqlite:"name"`
+	Email   *string `sqlite:"email"`
+	Scratch string  `sqlite:"-"`
+	audit
+}
+
+func TestStructs(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := sqlitex.ExecScript(conn, `CREATE TABLE people (id INTEGER PRIMARY KEY, name TEXT NOT NULL, email TEXT, created INTEGER);`); err != nil {
+		t.Fatal(err)
+	}
+
+	email := "ann@example.com"
+	insert := []person{
+		{Name: "ann", Email: &email, audit: audit{Created: 10}},
+		{Name: "bob", Scratch: "ignored", audit: audit{Created: 20}},
+		{ID: 10, Name: "cat"},
+	}
+	for i := range insert {
+		if err := sqlitex.InsertStruct(conn, "main.people", &insert[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var people []person
+	if err := sqlitex.QueryStructs(conn, &people, "SELECT * FROM people ORDER BY id;"); err != nil {
+		t.Fatal(err)
+	}
+	want := []person{
+		{ID: 1, Name: "ann", Email: &email, audit: audit{Created: 10}},
+		{ID: 2, Name: "bob", audit: audit{Created: 20}},
+		{ID: 10, Name: "cat"},
+	}
+	if !reflect.DeepEqual(people, want) {
+		t.Errorf("QueryStructs = %+v, want %+v", people, want)
+	}
+
+	var ptrs []*person
+	if err := sqlitex.QueryStructs(conn, &ptrs, "SELECT id, NAME FROM people WHERE id > ?;", 1); err != nil {
+		t.Fatal(err)
+	}
+	if len(ptrs) != 2 || ptrs[0].Name != "bob" || ptrs[1].ID != 10 {
+		t.Errorf("QueryStructs into []*person = %+v", ptrs)
+	}
+
+	var p person
+	if err := sqlitex.QueryStruct(conn, &p, "SELECT id, name FROM people WHERE name = ?;", "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if p.ID != 2 || p.Name != "bob" {
+		t.Errorf("QueryStruct = %+v", p)
+	}
+	if err := sqlitex.QueryStruct(conn, &p, "SELECT id FROM people WHERE id = 99;"); err != sqlitex.ErrNoResults {
+		t.Errorf("QueryStruct with no rows err = %v, want ErrNoResults", err)
+	}
+	if err := sqlitex.QueryStruct(conn, &p, "SELECT id FROM people;"); err != sqlitex.ErrMultipleResults {
+		t.Errorf("QueryStruct with many rows err = %v, want ErrMultipleResults", err)
+	}
+
+	err = sqlitex.QueryStructs(conn, &people, "SELECT id, 1 AS unknown FROM people;")
+	if _, ok := err.(*sqlitex.ScanError); !ok {
+		t.Errorf("unmapped column err = %v, want *sqlitex.ScanError", err)
+	}
+	if err := sqlitex.QueryStructs(conn, people, "SELECT id FROM people;"); err == nil {
+		t.Error("QueryStructs into a non-pointer succeeded")
+	}
+
+	type badTag struct {
+		A int `sqlite:"a,bogus"`
+	}
+	if err := sqlitex.InsertStruct(conn, "people", badTag{}); err == nil {
+		t.Error("InsertStruct with unknown tag option succeeded")
+	}
+}
+
+type Base struct {
+	ID   int64  `sqlite:"id"`
+	Note string `sqlite:"note"`
+}
+
+type Other struct {
+	Note string `sqlite:"note"`
+}
+
+type row struct {
+	*Base
+	*Other
+	Name string `sqlite:"name"`
+	Note string // shadows Base.Note and Other.Note
+}
+
+func TestStructsEmbeddedPtr(t *testing.T) {
+	conn, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := sqlitex.ExecScript(conn, `CREATE TABLE t (id INTEGER PRIMARY KEY, name TEXT, note TEXT);`); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlitex.InsertStruct(conn, "t", row{Base: &Base{ID: 7, Note: "hidden"}, Name: "ann", Note: "top"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sqlitex.InsertStruct(conn, "t", row{Name: "bob"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var rows []row
+	if err := sqlitex.QueryStructs(conn, &rows, "SELECT id, name, note FROM t ORDER BY id;"); err != nil {
+		t.Fatal(err)
+	}
+	want := []row{
+		{Base: &Base{ID: 7}, Name: "ann", Note: "top"},
+		{Base: &Base{ID: 8}, Name: "bob"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("QueryStructs = %+v, want %+v", rows, want)
+	}
+
+	// At the same depth, an untagged name is shadowed by a tagged one,
+	// and two tagged names leave the column unmapped.
+	type inner struct {
+		Name string
+	}
+	type tagged struct {
+		inner
+		Base
+		Other string `sqlite:"name"`
+	}
+	var tg tagged
+	if err := sqlitex.QueryStruct(conn, &tg, "SELECT name FROM t WHERE id = 7;"); err != nil {
+		t.Fatal(err)
+	}
+	if tg.Other != "ann" || tg.inner.Name != "" {
+		t.Errorf("QueryStruct = %+v, want Other set", tg)
+	}
+	type ambiguous struct {
+		Base
+		Other
+	}
+	var am ambiguous
+	err = sqlitex.QueryStruct(conn, &am, "SELECT note FROM t WHERE id = 7;")
+	if _, ok := err.(*sqlitex.ScanError); !ok {
+		t.Errorf("ambiguous column err = %v, want *sqlitex.ScanError", err)
+	}
+	if err := sqlitex.QueryStruct(conn, &am, "SELECT id FROM t WHERE id = 7;"); err != nil || am.Base.ID != 7 {
+		t.Errorf("QueryStruct = %+v, %v", am, err)
+	}
+}
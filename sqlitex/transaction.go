@@ -0,0 +1,184 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+// ImmediateTransaction starts a transaction with BEGIN IMMEDIATE,
+// which takes the database write lock at the start of the transaction
+// rather than at its first write. A transaction that reads and then
+// writes cannot then fail with SQLITE_BUSY_SNAPSHOT part way through.
+//
+// Unlike Save, the transaction cannot be nested, and starting it can
+// fail with SQLITE_BUSY once the connection's busy timeout expires,
+// so the error is returned rather than deferred.
+//
+// On success, endFn calls either COMMIT or ROLLBACK depending on
+// whether the parameter *error points to a nil or non-nil error.
+// This is designed to be deferred:
+//
+//	func doWork(conn *sqlite.Conn) (err error) {
+//		endFn, err := sqlitex.ImmediateTransaction(conn)
+//		if err != nil {
+//			return err
+//		}
+//		defer endFn(&err)
+//
+//		// ... do work in the transaction
+//	}
+//
+// https://www.sqlite.org/lang_transaction.html
+func ImmediateTransaction(conn *sqlite.Conn) (endFn func(*error), err error) {
+	return transaction(conn, "IMMEDIATE")
+}
+
+// ExclusiveTransaction starts a transaction with BEGIN EXCLUSIVE.
+// It is used like ImmediateTransaction.
+//
+// In WAL mode, EXCLUSIVE and IMMEDIATE are the same. Otherwise an
+// exclusive transaction also keeps other connections from reading
+// the database.
+func ExclusiveTransaction(conn *sqlite.Conn) (endFn func(*error), err error) {
+	return transaction(conn, "EXCLUSIVE")
+}
+
+func transaction(conn *sqlite.Conn, mode string) (endFn func(*error), err error) {
+	if err := ExecTransient(conn, "BEGIN "+mode+";", nil); err != nil {
+		return nil, err
+	}
+	tracer := conn.Tracer()
+	if tracer != nil {
+		tracer.Push("TX " + mode)
+	}
+	endFn = func(errp *error) {
+		if tracer != nil {
+			tracer.Pop()
+		}
+		recoverP := recover()
+
+		// If a query was interrupted or if a user exec'd COMMIT or
+		// ROLLBACK, then everything was already rolled back
+		// automatically, thus returning the connection to autocommit
+		// mode.
+		if conn.GetAutocommit() {
+			if recoverP != nil {
+				panic(recoverP)
+			}
+			return
+		}
+
+		if *errp == nil && recoverP == nil {
+			*errp = ExecTransient(conn, "COMMIT;", nil)
+			if *errp == nil || conn.GetAutocommit() {
+				return
+			}
+		}
+
+		orig := ""
+		if *errp != nil {
+			orig = (*errp).Error() + "\n\t"
+		}
+
+		// Always run ROLLBACK even if the connection has been interrupted.
+		oldDoneCh := conn.SetInterrupt(nil)
+		defer conn.SetInterrupt(oldDoneCh)
+
+		if err := ExecTransient(conn, "ROLLBACK;", nil); err != nil {
+			panic(orig + err.Error())
+		}
+		if recoverP != nil {
+			panic(recoverP)
+		}
+	}
+	return endFn, nil
+}
+
+// RetryTx runs fn in a transaction, running it again from the start
+// if it fails because the database is busy or locked: that is, with
+// an SQLITE_BUSY or SQLITE_LOCKED error code, including extended codes
+// such as SQLITE_BUSY_SNAPSHOT. Retries wait for a jittered, growing
+// delay and stop when ctx is done, in which case RetryTx returns
+// ctx.Err().
+//
+// The transaction is deferred, as with a plain BEGIN, so a fn that
+// only reads does not take the write lock. If fn returns an error the
+// transaction is rolled back, otherwise it is committed. Since fn may
+// be run more than once, it must not have side effects outside of the
+// database.
+//
+// The connection is interrupted when ctx is done, as with
+// Conn.SetInterrupt. RetryTx cannot retry inside an enclosing
+// transaction, as the enclosing transaction is what must be restarted,
+// so if conn is already in a transaction fn is run once in a Save.
+func RetryTx(ctx context.Context, conn *sqlite.Conn, fn func(conn *sqlite.Conn) error) (err error) {
+	if !conn.GetAutocommit() {
+		defer Save(conn)(&err)
+		return fn(conn)
+	}
+
+	oldDoneCh := conn.SetInterrupt(ctx.Done())
+	defer conn.SetInterrupt(oldDoneCh)
+
+	delay := retryMinDelay
+	for {
+		err = runTx(conn, fn)
+		if err != nil && ctx.Err() != nil {
+			// The attempt was most likely interrupted by ctx.
+			return ctx.Err()
+		}
+		if !retryable(err) {
+			return err
+		}
+		t := time.NewTimer(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1)))
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+		if delay *= 2; delay > retryMaxDelay {
+			delay = retryMaxDelay
+		}
+	}
+}
+
+const (
+	retryMinDelay = 2 * time.Millisecond
+	retryMaxDelay = 250 * time.Millisecond
+)
+
+func runTx(conn *sqlite.Conn, fn func(conn *sqlite.Conn) error) (err error) {
+	endFn, err := transaction(conn, "DEFERRED")
+	if err != nil {
+		return err
+	}
+	defer endFn(&err)
+	return fn(conn)
+}
+
+// retryable reports whether err means the transaction can be retried.
+func retryable(err error) bool {
+	switch sqlite.ErrCode(err) & 0xff { // primary result code
+	case sqlite.SQLITE_BUSY, sqlite.SQLITE_LOCKED:
+		return true
+	}
+	return false
+}
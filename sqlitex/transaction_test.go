@@ -0,0 +1,164 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+// openTxConns opens two connections to a new WAL database without
+// the shared cache, so they contend for locks as separate processes do.
+func openTxConns(t *testing.T) (conn0, conn1 *sqlite.Conn, cleanup func()) {
+	dir, err := ioutil.TempDir("", "sqlitex-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db := filepath.Join(dir, "tx.db")
+	flags := sqlite.SQLITE_OPEN_READWRITE | sqlite.SQLITE_OPEN_CREATE | sqlite.SQLITE_OPEN_WAL
+	if conn0, err = sqlite.OpenConn(db, flags); err != nil {
+		t.Fatal(err)
+	}
+	if conn1, err = sqlite.OpenConn(db, flags); err != nil {
+		t.Fatal(err)
+	}
+	if err := ExecScript(conn0, `CREATE TABLE t (c);`); err != nil {
+		t.Fatal(err)
+	}
+	return conn0, conn1, func() {
+		conn0.Close()
+		conn1.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestImmediateTransaction(t *testing.T) {
+	conn0, conn1, cleanup := openTxConns(t)
+	defer cleanup()
+
+	insert := func(conn *sqlite.Conn, fail bool) (err error) {
+		endFn, err := ImmediateTransaction(conn)
+		if err != nil {
+			return err
+		}
+		defer endFn(&err)
+		if err := Exec(conn, "INSERT INTO t (c) VALUES (1);", nil); err != nil {
+			return err
+		}
+		if fail {
+			return errors.New("fail")
+		}
+		return nil
+	}
+	if err := insert(conn0, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert(conn0, true); err == nil {
+		t.Fatal("failing transaction succeeded")
+	}
+	if n, err := ResultInt(conn0.Prep("SELECT count(*) FROM t;")); err != nil || n != 1 {
+		t.Errorf("count = %d, %v, want 1", n, err)
+	}
+	if !conn0.GetAutocommit() {
+		t.Error("transaction left open")
+	}
+
+	// While conn0 holds the write lock, conn1 cannot begin.
+	endFn, err := ExclusiveTransaction(conn0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn1.SetBusyTimeout(0)
+	if _, err := ImmediateTransaction(conn1); sqlite.ErrCode(err) != sqlite.SQLITE_BUSY {
+		t.Errorf("ImmediateTransaction err = %v, want SQLITE_BUSY", err)
+	}
+	err = nil
+	endFn(&err)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRetryTx(t *testing.T) {
+	conn0, conn1, cleanup := openTxConns(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// The first attempt reads, then a write on conn1 invalidates its
+	// snapshot, so its own write fails with SQLITE_BUSY_SNAPSHOT.
+	attempts := 0
+	err := RetryTx(ctx, conn0, func(conn *sqlite.Conn) error {
+		attempts++
+		n, err := ResultInt(conn.Prep("SELECT count(*) FROM t;"))
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			if err := Exec(conn1, "INSERT INTO t (c) VALUES (1);", nil); err != nil {
+				return err
+			}
+		}
+		return Exec(conn, "INSERT INTO t (c) VALUES (?);", nil, n+10)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attempts != 2 {
+		t.Errorf("attempts = %d, want 2", attempts)
+	}
+	if n, err := ResultInt(conn0.Prep("SELECT max(c) FROM t;")); err != nil || n != 11 {
+		t.Errorf("max(c) = %d, %v, want 11", n, err)
+	}
+
+	// Errors that are not about locking are not retried.
+	attempts = 0
+	fail := errors.New("fail")
+	err = RetryTx(ctx, conn0, func(conn *sqlite.Conn) error {
+		attempts++
+		return fail
+	})
+	if err != fail || attempts != 1 {
+		t.Errorf("err = %v after %d attempts, want fail after 1", err, attempts)
+	}
+
+	// A lock that is never released retries until ctx is done.
+	endFn, err := ImmediateTransaction(conn1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn0.SetBusyTimeout(0)
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	attempts = 0
+	err = RetryTx(ctx, conn0, func(conn *sqlite.Conn) error {
+		attempts++
+		return Exec(conn, "INSERT INTO t (c) VALUES (2);", nil)
+	})
+	if err != context.DeadlineExceeded || attempts < 2 {
+		t.Errorf("err = %v after %d attempts, want context.DeadlineExceeded", err, attempts)
+	}
+	err = nil
+	endFn(&err)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex
+
+import (
+	"context"
+
+	"crawshaw.io/sqlite"
+)
+
+// VacuumInto writes a vacuumed copy of the schema database on conn to
+// a new database file at dstPath, with VACUUM INTO. If schema is "",
+// then "main" is used. dstPath may also be a URI if conn was opened
+// with SQLITE_OPEN_URI, and the file must not already exist, or must
+// be empty.
+//
+// The copy is made in a single read transaction, so it is consistent,
+// and in WAL mode writers can continue while it is made. Unlike
+// Backup, the copy is compacted, but the read transaction is held
+// until it is complete. conn must not be in a transaction.
+//
+// The connection is interrupted when ctx is done, as with
+// Conn.SetInterrupt, and VacuumInto returns ctx.Err(). A partial
+// file may then be left at dstPath.
+//
+// https://www.sqlite.org/lang_vacuum.html#vacuuminto
+func VacuumInto(ctx context.Context, conn *sqlite.Conn, schema, dstPath string) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if schema == "" {
+		schema = "main"
+	}
+	oldDoneCh := conn.SetInterrupt(ctx.Done())
+	defer conn.SetInterrupt(oldDoneCh)
+
+	err := ExecTransient(conn, "VACUUM "+quoteIdent(schema)+" INTO ?;", nil, dstPath)
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
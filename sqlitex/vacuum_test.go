@@ -0,0 +1,69 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlitex_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"crawshaw.io/sqlite"
+	"crawshaw.io/sqlite/sqlitex"
+)
+
+func TestVacuumInto(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sqlitex-vacuum-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := openBackupSrc(t, dir)
+	defer src.Close()
+	if err := sqlitex.Exec(src, "DELETE FROM t WHERE rowid > 10;", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	dstPath := filepath.Join(dir, "dst.db")
+	if err := sqlitex.VacuumInto(context.Background(), src, "", dstPath); err != nil {
+		t.Fatal(err)
+	}
+	dst, err := sqlite.OpenConn(dstPath, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if n, err := sqlitex.ResultInt(dst.Prep("SELECT count(*) FROM t;")); err != nil || n != 10 {
+		t.Errorf("dst count = %d, %v, want 10", n, err)
+	}
+	srcPages, _ := sqlitex.ResultInt(src.Prep("PRAGMA page_count;"))
+	dstPages, _ := sqlitex.ResultInt(dst.Prep("PRAGMA page_count;"))
+	if dstPages >= srcPages {
+		t.Errorf("dst has %d pages, src %d, want fewer", dstPages, srcPages)
+	}
+
+	// The destination must not already hold a database.
+	if err := sqlitex.VacuumInto(nil, src, "main", dstPath); err == nil {
+		t.Error("VacuumInto overwrote a database")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = sqlitex.VacuumInto(ctx, src, "", filepath.Join(dir, "cancel.db"))
+	if err != context.Canceled {
+		t.Errorf("VacuumInto with done ctx err = %v, want context.Canceled", err)
+	}
+}
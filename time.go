@@ -0,0 +1,195 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlite
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TimeFormat is the storage format used for time.Time values by
+// Stmt.BindTime and Stmt.ColumnTime.
+//
+// SQLite has no time type. Its date and time functions accept text,
+// Unix seconds, and Julian day numbers.
+//
+// https://www.sqlite.org/lang_datefunc.html
+type TimeFormat int
+
+const (
+	// TimeFormatRFC3339Nano stores text in UTC with nine fractional
+	// digits, as in "2006-01-02T15:04:05.000000000Z". The fixed width
+	// means the text sorts in time order, and it is understood by the
+	// SQLite date and time functions.
+	TimeFormatRFC3339Nano TimeFormat = iota
+
+	// TimeFormatUnix stores an INTEGER number of seconds since
+	// the Unix epoch, as produced by unixepoch().
+	TimeFormatUnix
+
+	// TimeFormatUnixMilli stores an INTEGER number of milliseconds
+	// since the Unix epoch.
+	TimeFormatUnixMilli
+
+	// TimeFormatJulianDay stores a FLOAT Julian day number,
+	// as produced by julianday().
+	TimeFormatJulianDay
+)
+
+func (f TimeFormat) String() string {
+	switch f {
+	case TimeFormatRFC3339Nano:
+		return "TimeFormatRFC3339Nano"
+	case TimeFormatUnix:
+		return "TimeFormatUnix"
+	case TimeFormatUnixMilli:
+		return "TimeFormatUnixMilli"
+	case TimeFormatJulianDay:
+		return "TimeFormatJulianDay"
+	default:
+		var buf [20]byte
+		return "TimeFormat(" + string(itoa(buf[:], int64(f))) + ")"
+	}
+}
+
+// SetTimeFormat sets the format used to store and interpret time.Time
+// values on this connection. The default is TimeFormatRFC3339Nano.
+func (conn *Conn) SetTimeFormat(f TimeFormat) {
+	conn.timeFormat = f
+}
+
+// TimeFormat reports the format set by SetTimeFormat.
+func (conn *Conn) TimeFormat() TimeFormat {
+	return conn.timeFormat
+}
+
+const (
+	// timeText is the layout of TimeFormatRFC3339Nano.
+	timeText = "2006-01-02T15:04:05.000000000Z"
+
+	// julianDayUnixEpoch is the Julian day number of 1970-01-01T00:00:00Z.
+	julianDayUnixEpoch = 2440587.5
+)
+
+// BindTime binds value to a numbered stmt parameter in the
+// connection's TimeFormat.
+//
+// Parameter indices start at 1.
+func (stmt *Stmt) BindTime(param int, value time.Time) {
+	if stmt.stmt == nil {
+		return
+	}
+	switch stmt.conn.timeFormat {
+	case TimeFormatUnix:
+		stmt.BindInt64(param, value.Unix())
+	case TimeFormatUnixMilli:
+		stmt.BindInt64(param, value.Unix()*1e3+int64(value.Nanosecond())/1e6)
+	case TimeFormatJulianDay:
+		secs := float64(value.Unix()) + float64(value.Nanosecond())/1e9
+		stmt.BindFloat(param, secs/86400+julianDayUnixEpoch)
+	default:
+		stmt.BindText(param, value.UTC().Format(timeText))
+	}
+}
+
+// SetTime binds a time to a parameter using a column name.
+// An invalid parameter name will cause the call to Step to return an error.
+func (stmt *Stmt) SetTime(param string, value time.Time) {
+	stmt.BindTime(stmt.findBindName("SetTime", param), value)
+}
+
+// timeLayouts are the text formats recognized by ColumnTime,
+// most specific first. They cover RFC 3339 and the output of
+// the SQLite date and time functions.
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// ColumnTime returns a query result as a time.Time.
+//
+// TEXT is parsed as RFC 3339 or as any of the formats produced by
+// the SQLite date and time functions, such as datetime(); times
+// without a zone are UTC. INTEGER is Unix seconds, or milliseconds
+// if the connection's TimeFormat is TimeFormatUnixMilli. FLOAT is a
+// Julian day, unless the TimeFormat is TimeFormatUnix or
+// TimeFormatUnixMilli, when it is fractional seconds or milliseconds.
+// NULL is the zero time.
+//
+// Any other value is reported as an SQLITE_MISMATCH error.
+//
+// Column indices start at 0.
+func (stmt *Stmt) ColumnTime(col int) (time.Time, error) {
+	format := stmt.conn.timeFormat
+	switch stmt.ColumnType(col) {
+	case SQLITE_NULL:
+		return time.Time{}, nil
+	case SQLITE_INTEGER:
+		v := stmt.ColumnInt64(col)
+		if format == TimeFormatUnixMilli {
+			return time.Unix(v/1e3, v%1e3*1e6).UTC(), nil
+		}
+		return time.Unix(v, 0).UTC(), nil
+	case SQLITE_FLOAT:
+		v := stmt.ColumnFloat(col)
+		var secs float64
+		switch format {
+		case TimeFormatUnix:
+			secs = v
+		case TimeFormatUnixMilli:
+			secs = v / 1e3
+		default:
+			secs = (v - julianDayUnixEpoch) * 86400
+		}
+		whole := math.Floor(secs)
+		return time.Unix(int64(whole), int64(math.Round((secs-whole)*1e9))).UTC(), nil
+	case SQLITE_TEXT:
+		s := strings.TrimSpace(stmt.ColumnText(col))
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, nil
+			}
+		}
+		return time.Time{}, Error{
+			Code:  SQLITE_MISMATCH,
+			Loc:   "Stmt.ColumnTime",
+			Query: stmt.query,
+			Msg:   "cannot parse " + strconv.Quote(s) + " as a time",
+		}
+	}
+	return time.Time{}, Error{
+		Code:  SQLITE_MISMATCH,
+		Loc:   "Stmt.ColumnTime",
+		Query: stmt.query,
+		Msg:   "cannot read BLOB as a time",
+	}
+}
+
+// GetTime returns a query result value for colName as a time.Time,
+// as by ColumnTime.
+func (stmt *Stmt) GetTime(colName string) (time.Time, error) {
+	col, found := stmt.colNames[colName]
+	if !found {
+		return time.Time{}, nil
+	}
+	return stmt.ColumnTime(col)
+}
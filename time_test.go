@@ -0,0 +1,97 @@
+// Copyright (c) 2018 David Crawshaw <david@zentus.com>
+//
+// Permission to use, copy, modify, and distribute this software for any
+// purpose with or without fee is hereby granted, provided that the above
+// copyright notice and this permission notice appear in all copies.
+//
+// THE SOFTWARE IS PROVIDED "AS IS" AND THE AUTHOR DISCLAIMS ALL WARRANTIES
+// WITH REGARD TO THIS SOFTWARE INCLUDING ALL IMPLIED WARRANTIES OF
+// MERCHANTABILITY AND FITNESS. IN NO EVENT SHALL THE AUTHOR BE LIABLE FOR
+// ANY SPECIAL, DIRECT, INDIRECT, OR CONSEQUENTIAL DAMAGES OR ANY DAMAGES
+// WHATSOEVER RESULTING FROM LOSS OF USE, DATA OR PROFITS, WHETHER IN AN
+// ACTION OF CONTRACT, NEGLIGENCE OR OTHER TORTIOUS ACTION, ARISING OUT OF
+// OR IN CONNECTION WITH THE USE OR PERFORMANCE OF THIS SOFTWARE.
+
+package sqlite_test
+
+import (
+	"testing"
+	"time"
+
+	"crawshaw.io/sqlite"
+)
+
+func TestTime(t *testing.T) {
+	c, err := sqlite.OpenConn(":memory:", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	when := time.Date(2021, 3, 4, 5, 6, 7, 890123456, time.FixedZone("X", 3600))
+	tests := []struct {
+		format sqlite.TimeFormat
+		typ    sqlite.ColumnType
+		prec   time.Duration // allowed round trip error
+		query  string        // d is $t converted by datetime()
+	}{
+		{sqlite.TimeFormatRFC3339Nano, sqlite.SQLITE_TEXT, 0, "SELECT $t AS t, datetime($t) AS d;"},
+		{sqlite.TimeFormatUnix, sqlite.SQLITE_INTEGER, time.Second, "SELECT $t AS t, datetime($t, 'unixepoch') AS d;"},
+		{sqlite.TimeFormatUnixMilli, sqlite.SQLITE_INTEGER, time.Millisecond, "SELECT $t AS t, datetime($t / 1000, 'unixepoch') AS d;"},
+		{sqlite.TimeFormatJulianDay, sqlite.SQLITE_FLOAT, 100 * time.Microsecond, "SELECT $t AS t, datetime($t) AS d;"},
+	}
+	for _, test := range tests {
+		t.Run(test.format.String(), func(t *testing.T) {
+			c.SetTimeFormat(test.format)
+			stmt := c.Prep(test.query)
+			stmt.SetTime("$t", when)
+			if hasRow, err := stmt.Step(); err != nil {
+				t.Fatal(err)
+			} else if !hasRow {
+				t.Fatal("no row")
+			}
+			defer stmt.Reset()
+
+			if typ := stmt.GetType("t"); typ != test.typ {
+				t.Errorf("stored as %v, want %v", typ, test.typ)
+			}
+			got, err := stmt.GetTime("t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d := got.Sub(when); d < -test.prec || d > test.prec {
+				t.Errorf("round trip = %v, want %v", got, when)
+			}
+			got, err = stmt.GetTime("d")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := when.Truncate(time.Second); !got.Equal(want) {
+				t.Errorf("datetime() = %q read as %v, want %v", stmt.GetText("d"), got, want)
+			}
+		})
+	}
+
+	c.SetTimeFormat(sqlite.TimeFormatRFC3339Nano)
+	stmt := c.Prep("SELECT unixepoch('2021-03-04 04:06:07'), julianday('2021-03-04 04:06:07'), NULL, 'tomorrow', x'00';")
+	if _, err := stmt.Step(); err != nil {
+		t.Fatal(err)
+	}
+	defer stmt.Reset()
+	want := time.Date(2021, 3, 4, 4, 6, 7, 0, time.UTC)
+	for col := 0; col < 2; col++ {
+		if got, err := stmt.ColumnTime(col); err != nil {
+			t.Errorf("column %d: %v", col, err)
+		} else if d := got.Sub(want); d < -time.Millisecond || d > time.Millisecond {
+			t.Errorf("column %d = %v, want %v", col, got, want)
+		}
+	}
+	if got, err := stmt.ColumnTime(2); err != nil || !got.IsZero() {
+		t.Errorf("NULL = %v, %v, want zero time", got, err)
+	}
+	for col := 3; col < 5; col++ {
+		if _, err := stmt.ColumnTime(col); sqlite.ErrCode(err) != sqlite.SQLITE_MISMATCH {
+			t.Errorf("column %d err = %v, want SQLITE_MISMATCH", col, err)
+		}
+	}
+}